@@ -0,0 +1,79 @@
+package ovskv
+
+import (
+	"context"
+
+	"github.com/ebay/libovsdb"
+)
+
+type serverReadKey struct{}
+
+// ServerRead returns context which makes reads done with it bypass the
+// cache and go to ovsdb-server
+func ServerRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serverReadKey{}, true)
+}
+
+// SetCache enables local replica of the namespace kept current by OVSDB
+// monitor, GetKV, GetKVNodes and Load are served from it then. Writes made
+// through this OvsKVImpl are visible to reads once they return, as
+// ovsdb-server sends monitor updates ahead of the transaction reply.
+// Writes of other clients show up shortly after they commit, use
+// ServerRead where that is not enough. While disconnected reads go to the
+// server.
+func (o *OvsKVImpl) SetCache(enable bool) error {
+	if enable {
+		if _, err := o.watchHub(); err != nil {
+			return err
+		}
+	}
+	o.mu.Lock()
+	o.cache = enable
+	o.mu.Unlock()
+	return nil
+}
+
+// cached returns rows matching op of the key from the replica, false if
+// the cache is not to be used
+func (o *OvsKVImpl) cached(ctx context.Context, op, key string) ([]libovsdb.ResultRow, bool) {
+	if ctx.Value(serverReadKey{}) != nil {
+		return nil, false
+	}
+	o.mu.RLock()
+	hub := o.watch
+	enabled := o.cache
+	o.mu.RUnlock()
+	if !enabled || hub == nil {
+		return nil, false
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if !hub.live {
+		return nil, false
+	}
+
+	rows := []libovsdb.ResultRow{}
+	if op == "==" {
+		if uuid, ok := hub.keys[key]; ok {
+			rows = append(rows, hub.rows[uuid].resultRow(uuid))
+		}
+		return rows, true
+	}
+	for uuid, row := range hub.rows {
+		if pathMatch(op, row.key, key) {
+			rows = append(rows, row.resultRow(uuid))
+		}
+	}
+	return rows, true
+}
+
+// resultRow returns the row as select of ovsdb-server would
+func (r watchRow) resultRow(uuid string) libovsdb.ResultRow {
+	row := make(libovsdb.ResultRow, len(r.fields)+1)
+	for k, v := range r.fields {
+		row[k] = v
+	}
+	row["_uuid"] = libovsdb.UUID{GoUUID: uuid}
+	return row
+}
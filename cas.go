@@ -0,0 +1,137 @@
+package ovskv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/ebay/libovsdb"
+)
+
+// revisionWait fails the transaction unless rows matching condition are
+// exactly one row at the revision
+func revisionWait(table string, condition []interface{}, revision uint32) libovsdb.Operation {
+	return libovsdb.Operation{
+		Op:      OP_WAIT,
+		Table:   table,
+		Timeout: WAIT_TIMEOUT,
+		Where:   []interface{}{condition},
+		Columns: []string{"revision"},
+		Until:   "==",
+		Rows:    []map[string]interface{}{{"revision": revision}},
+	}
+}
+
+// conflictError marks err as ErrConflict if the transaction failed on
+// revision check of one of the checked keys
+func conflictError(err error, checked map[string]bool) error {
+	var terr *TransactError
+	if !errors.As(err, &terr) || !checked[terr.Key] {
+		return err
+	}
+	// revision differs, or key expected to be new was inserted meanwhile
+	if (terr.Op == OP_WAIT && terr.Code == "timed out") ||
+		(terr.Op == "" && terr.Code == "constraint violation") {
+		terr.Conflict = true
+	}
+	return err
+}
+
+// SetKVMIfVersion sets multi-key value if the key is still at revision
+// version, as returned by GetKV. Zero version creates the key and fails if
+// it exists. Returns new revision of the key.
+func (o *OvsKVImpl) SetKVMIfVersion(key string, val map[string]string, version uint32) (uint32, error) {
+	return o.SetKVMIfVersionCtx(context.Background(), key, val, version)
+}
+
+// SetKVMIfVersionCtx works as SetKVMIfVersion, giving up when ctx is done
+func (o *OvsKVImpl) SetKVMIfVersionCtx(ctx context.Context, key string, val map[string]string, version uint32) (uint32, error) {
+	if err := o.Begin().SetMIfVersion(key, val, version).CommitCtx(ctx); err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// SetKVIfVersion sets value if the key is still at revision version
+func (o *OvsKVImpl) SetKVIfVersion(key, val string, version uint32) (uint32, error) {
+	return o.SetKVMIfVersion(key, o.V(val), version)
+}
+
+// SetKVIfVersionCtx works as SetKVIfVersion, giving up when ctx is done
+func (o *OvsKVImpl) SetKVIfVersionCtx(ctx context.Context, key, val string, version uint32) (uint32, error) {
+	return o.SetKVMIfVersionCtx(ctx, key, o.V(val), version)
+}
+
+// DeleteKVIfVersion deletes the key if it is still at revision version.
+// Zero version requires the key not to exist, i.e. it fails with
+// ErrConflict if the key exists and deletes nothing otherwise.
+func (o *OvsKVImpl) DeleteKVIfVersion(key string, version uint32) error {
+	return o.DeleteKVIfVersionCtx(context.Background(), key, version)
+}
+
+// DeleteKVIfVersionCtx works as DeleteKVIfVersion, giving up when ctx is done
+func (o *OvsKVImpl) DeleteKVIfVersionCtx(ctx context.Context, key string, version uint32) error {
+	return o.Begin().DeleteIfVersion(key, version).CommitCtx(ctx)
+}
+
+// versionWriter adds rows of saveField to Txn guarded by revisions
+// captured on Load
+type versionWriter struct {
+	o       *OvsKVImpl
+	t       *Txn
+	keys    []string
+	deleted []string
+}
+
+func (w *versionWriter) setKVM(_ context.Context, key string, val map[string]string) error {
+	w.t.SetMIfVersion(key, val, w.o.info[key].version)
+	w.keys = append(w.keys, key)
+	return w.t.err
+}
+
+// deleteKV removes the key if it is at the loaded revision, keys under it
+// are removed unchecked
+func (w *versionWriter) deleteKV(_ context.Context, key string) error {
+	if version := w.o.info[key].version; version > 0 {
+		w.t.DeleteIfVersion(key, version)
+		w.deleted = append(w.deleted, key)
+	}
+	w.t.Delete("includes", key)
+	return w.t.err
+}
+
+// SaveFieldIfUnchanged works as SaveField, but saves in one transaction
+// which fails with ErrConflict if any of the rows was changed since it was
+// loaded. Rows which were not loaded are expected not to exist.
+func (o *OvsKVImpl) SaveFieldIfUnchanged(field interface{}) error {
+	return o.SaveFieldIfUnchangedCtx(context.Background(), field)
+}
+
+// SaveFieldIfUnchangedCtx works as SaveFieldIfUnchanged, giving up when ctx
+// is done
+func (o *OvsKVImpl) SaveFieldIfUnchangedCtx(ctx context.Context, field interface{}) error {
+	path, _, err := o.getInfo(field)
+	if err != nil {
+		return err
+	}
+
+	w := &versionWriter{o: o, t: o.Begin()}
+	if err := o.saveField(ctx, w, reflect.ValueOf(field), path); err != nil {
+		return err
+	}
+	if err := w.t.CommitCtx(ctx); err != nil {
+		return err
+	}
+
+	for _, key := range w.keys {
+		i := o.info[key]
+		i.version++
+		o.info[key] = i
+	}
+	for _, key := range w.deleted {
+		i := o.info[key]
+		i.version = 0
+		o.info[key] = i
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// exportCmd prints subtree of prefix as nested document import takes
+// back, YAML unless JSON output is asked for
+func exportCmd(cfg *config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("export takes one PREFIX")
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	doc, err := ovs.Export(prefix)
+	if err != nil {
+		return err
+	}
+	if cfg.output == "table" {
+		cfg.output = "yaml"
+	}
+	return printDoc(cfg, doc)
+}
+
+// importCmd sets keys of a JSON or YAML document export printed under
+// prefix, all in one transaction. "-" reads the document from stdin.
+func importCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "key to import the document under")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import needs FILE or -")
+	}
+	var b []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	// YAML parser takes JSON as well
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	return ovs.Import(*prefix, doc)
+}
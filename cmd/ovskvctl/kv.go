@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ebay/libovsdb"
+
+	"../.."
+)
+
+// getCmd prints the key, or with -prefix every key under it
+func getCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	prefix := fs.Bool("prefix", false, "get all keys under KEY")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("get needs KEY")
+	}
+	key := fs.Arg(0)
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	var entries []entry
+	if *prefix {
+		result, err := ovs.List(key, ovskv.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, item := range result.Items {
+			entries = append(entries, entry{Key: item.Key, Revision: item.Revision, Data: item.Data})
+		}
+	} else {
+		rows, err := ovs.GetKVM("==", key)
+		if err != nil {
+			return err
+		}
+		for _, row := range *rows {
+			entries = append(entries, rowEntry(key, row))
+		}
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%w: %s", ovskv.ErrNotFound, key)
+	}
+	return printEntries(cfg, entries)
+}
+
+// rowEntry converts row GetKVM returned for the key
+func rowEntry(key string, row libovsdb.ResultRow) entry {
+	e := entry{Key: key, Data: make(map[string]string)}
+	if data, ok := row["data"].(libovsdb.OvsMap); ok {
+		for k, v := range data.GoMap {
+			e.Data[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+		}
+	}
+	switch v := row["revision"].(type) {
+	case float64:
+		e.Revision = uint32(v)
+	case int:
+		e.Revision = uint32(v)
+	}
+	return e
+}
+
+// putCmd sets single value, or with -m multi-key value given as
+// NAME=VALUE pairs
+func putCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	multi := fs.Bool("m", false, "multi-key value given as NAME=VALUE pairs")
+	fs.Parse(args)
+
+	var val map[string]string
+	switch {
+	case *multi && fs.NArg() >= 2:
+		val = make(map[string]string)
+		for _, pair := range fs.Args()[1:] {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid pair %q, expected NAME=VALUE", pair)
+			}
+			val[parts[0]] = parts[1]
+		}
+	case !*multi && fs.NArg() == 2:
+		val = map[string]string{"v": fs.Arg(1)}
+	default:
+		return fmt.Errorf("put needs KEY VALUE or -m KEY NAME=VALUE...")
+	}
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	_, err = ovs.SetKVM(fs.Arg(0), val)
+	return err
+}
+
+// delCmd deletes the key, or with -r the key and all keys under it
+func delCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("del", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "delete all keys under KEY as well")
+	dryRun := fs.Bool("dry-run", false, "only list keys -r would delete")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("del needs KEY")
+	}
+	key := fs.Arg(0)
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	if !*recursive {
+		n, err := ovs.DeleteKV("==", key)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%w: %s", ovskv.ErrNotFound, key)
+		}
+		return nil
+	}
+
+	keys, err := ovs.DeleteTree(key, ovskv.DeleteOptions{DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		fmt.Println(k)
+	}
+	return nil
+}
+
+// lsCmd prints keys under prefix as a tree, JSON and YAML output nest
+// them as objects of their children with data maps of the keys as leaves.
+// A key which has both data and children keeps its data under
+// ovskv.EXPORT_DATA next to the children, as Export does.
+func lsCmd(cfg *config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("ls takes one PREFIX")
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = strings.TrimSuffix(args[0], "/")
+	}
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	result, err := ovs.List(prefix, ovskv.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(result.Items) == 0 && prefix != "" {
+		return fmt.Errorf("%w: %s", ovskv.ErrNotFound, prefix)
+	}
+	root := &lsNode{}
+	for _, item := range result.Items {
+		n := root
+		for _, name := range strings.Split(strings.TrimPrefix(item.Key, prefix), "/") {
+			if name == "" {
+				continue
+			}
+			n = n.child(name)
+		}
+		n.data = item.Data
+	}
+	if cfg.output != "table" {
+		return printDoc(cfg, root.doc())
+	}
+	switch {
+	case prefix == "":
+		fmt.Println("/")
+	case root.data != nil:
+		fmt.Printf("%s = %s\n", prefix, formatData(root.data))
+	default:
+		fmt.Println(prefix)
+	}
+	root.print("")
+	return nil
+}
+
+// lsNode is a key of the listed tree, it may have both data and children
+type lsNode struct {
+	data     map[string]string // nil if there is no such key
+	children map[string]*lsNode
+}
+
+func (n *lsNode) child(name string) *lsNode {
+	if n.children == nil {
+		n.children = make(map[string]*lsNode)
+	}
+	child, ok := n.children[name]
+	if !ok {
+		child = &lsNode{}
+		n.children[name] = child
+	}
+	return child
+}
+
+func (n *lsNode) doc() interface{} {
+	if n.children == nil {
+		return n.data
+	}
+	doc := make(map[string]interface{}, len(n.children)+1)
+	for name, child := range n.children {
+		doc[name] = child.doc()
+	}
+	if n.data != nil {
+		doc[ovskv.EXPORT_DATA] = n.data
+	}
+	return doc
+}
+
+func (n *lsNode) print(indent string) {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		child := n.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		line := name
+		if child.children != nil {
+			line += "/"
+		}
+		if child.data != nil {
+			line += " = " + formatData(child.data)
+		}
+		fmt.Fprintf(os.Stdout, "%s%s%s\n", indent, branch, line)
+		child.print(indent + next)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"../.."
+)
+
+// config is what global flags tell about the database
+type config struct {
+	db        string
+	connect   string
+	namespace string
+	shards    int
+	columns   ovskv.Columns
+	output    string
+}
+
+type command struct {
+	usage string
+	run   func(cfg *config, args []string) error
+}
+
+var commands = map[string]command{
+	"get":      {"get [-prefix] KEY", getCmd},
+	"put":      {"put KEY VALUE | put -m KEY NAME=VALUE...", putCmd},
+	"del":      {"del [-r] [-dry-run] KEY", delCmd},
+	"ls":       {"ls [PREFIX]", lsCmd},
+	"watch":    {"watch [PREFIX]", watchCmd},
+	"export":   {"export [PREFIX]", exportCmd},
+	"import":   {"import [-prefix KEY] FILE|-", importCmd},
+	"snapshot": {"snapshot FILE", snapshotCmd},
+	"restore":  {"restore [-from KEY] [-to KEY] FILE", restoreCmd},
+	"schema":   {"schema generate|version|migrate [-dry-run]", schemaCmd},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: ovskvctl [flags] command [args]\n\ncommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func defaultConnect() string {
+	if connect := os.Getenv("OVSKV_CONNECT"); connect != "" {
+		return connect
+	}
+	return "tcp:127.0.0.1:6641"
+}
+
+func main() {
+	var cfg config
+	var extra string
+	flag.StringVar(&cfg.db, "db", "TestKV", "database name")
+	flag.StringVar(&cfg.connect, "connect", defaultConnect(), "comma separated remotes, or memory:")
+	flag.StringVar(&cfg.namespace, "ns", "Zone_", "namespace, prefix of the table names")
+	flag.IntVar(&cfg.shards, "shards", 1, "number of shard tables")
+	flag.StringVar(&cfg.columns.Path, "path-column", ovskv.PATH_COLUMN, "name of the path column")
+	flag.StringVar(&cfg.columns.Data, "data-column", ovskv.DATA_COLUMN, "name of the data column")
+	flag.StringVar(&extra, "extra-columns", "", "comma separated extra columns as name=type")
+	flag.StringVar(&cfg.output, "o", "table", "output format, table, json or yaml")
+	flag.Usage = usage
+	flag.Parse()
+
+	if extra != "" {
+		cfg.columns.Extra = make(map[string]string)
+		for _, column := range strings.Split(extra, ",") {
+			parts := strings.SplitN(column, "=", 2)
+			if len(parts) != 2 {
+				fail(fmt.Errorf("invalid extra column %q, expected name=type", column))
+			}
+			cfg.columns.Extra[parts[0]] = parts[1]
+		}
+	}
+
+	switch cfg.output {
+	case "table", "json", "yaml":
+	default:
+		fail(fmt.Errorf("unknown output format %s", cfg.output))
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ovskvctl: unknown command %s\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(&cfg, args[1:]); err != nil {
+		fail(err)
+	}
+}
+
+// open connects to the database as configured by the flags
+func open(cfg *config) (*ovskv.OvsKVImpl, error) {
+	ovs, err := ovskv.Init(cfg.db, cfg.connect, cfg.namespace, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.columns.Path != ovskv.PATH_COLUMN || cfg.columns.Data != ovskv.DATA_COLUMN || cfg.columns.Extra != nil {
+		if err := ovs.SetColumns(cfg.columns); err != nil {
+			ovs.Disconnect()
+			return nil, err
+		}
+	}
+	if err := ovs.SetShards(cfg.shards); err != nil {
+		ovs.Disconnect()
+		return nil, err
+	}
+	return ovs, nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "ovskvctl: %s\n", strings.TrimSuffix(err.Error(), "\n"))
+	os.Exit(1)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"../.."
+)
+
+// run runs command of args and returns what it printed
+func run(t *testing.T, cfg config, args []string) (string, error) {
+	r, w, err := os.Pipe()
+	assert.Equal(t, err, nil)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+
+	cmd, ok := commands[args[0]]
+	assert.Equal(t, true, ok)
+	err = cmd.run(&cfg, args[1:])
+
+	os.Stdout = stdout
+	w.Close()
+	return <-out, err
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ovskvctl")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "backup.json")
+
+	cfg := config{
+		db:        "TestCtl",
+		connect:   ovskv.MEMORY_CONNECT,
+		namespace: "Zone_",
+		shards:    1,
+		columns:   ovskv.Columns{Path: ovskv.PATH_COLUMN, Data: ovskv.DATA_COLUMN},
+		output:    "table",
+	}
+	ovs, err := open(&cfg)
+	assert.Equal(t, err, nil)
+	_, err = ovs.DeleteAll(cfg.namespace)
+	assert.Equal(t, err, nil)
+	ovs.Disconnect()
+
+	// steps share the database, FILE stands for the snapshot file
+	steps := []struct {
+		args   string
+		output string // "" for table
+		want   string
+		err    bool
+	}{
+		{args: "put /a/b 1"},
+		{args: "put -m /a/c x=1 y=2"},
+		{args: "put /a/c/d 2"},
+		{args: "put rel/x 3"},
+		{args: "put /keep k"},
+		{args: "put /a/b", err: true},
+
+		{args: "get /a/b", want: "KEY   REVISION  VALUE\n/a/b  1         1\n"},
+		{args: "get /a/c", want: "KEY   REVISION  VALUE\n/a/c  1         x=1 y=2\n"},
+		{args: "get /a", err: true},
+		{args: "get -prefix /a/c", want: "KEY     REVISION  VALUE\n/a/c    1         x=1 y=2\n/a/c/d  1         2\n"},
+		{args: "get /a/b", output: "json", want: "[\n  {\n    \"key\": \"/a/b\",\n    \"revision\": 1,\n    \"data\": {\n      \"v\": \"1\"\n    }\n  }\n]\n"},
+
+		// key with both data and children, relative key kept relative
+		{args: "ls /a", want: "/a\n├── b = 1\n└── c/ = x=1 y=2\n    └── d = 2\n"},
+		{args: "ls rel", want: "rel\n└── x = 3\n"},
+		{args: "ls /a/c", output: "yaml", want: "=:\n  x: \"1\"\n  \"y\": \"2\"\nd:\n  v: \"2\"\n"},
+
+		{args: "snapshot FILE", want: "5 keys saved to FILE\n"},
+
+		{args: "del -r -dry-run /a", want: "/a/b\n/a/c\n/a/c/d\n"},
+		{args: "get /a/b", want: "KEY   REVISION  VALUE\n/a/b  1         1\n"},
+		{args: "del -r /a", want: "/a/b\n/a/c\n/a/c/d\n"},
+		{args: "get /a/b", err: true},
+		{args: "del /keep"},
+		{args: "del /keep", err: true},
+		{args: "put /keep k2"},
+
+		// restore in place keeps keys outside of -from
+		{args: "put /a/new n"},
+		{args: "restore -from /a FILE", want: "3 keys restored\n"},
+		{args: "ls /a", want: "/a\n├── b = 1\n└── c/ = x=1 y=2\n    └── d = 2\n"},
+		{args: "get /keep", want: "KEY    REVISION  VALUE\n/keep  1         k2\n"},
+		{args: "ls rel", want: "rel\n└── x = 3\n"},
+
+		// restore elsewhere keeps the source
+		{args: "restore -from /a -to /copy FILE", want: "3 keys restored\n"},
+		{args: "ls /copy", want: "/copy\n├── b = 1\n└── c/ = x=1 y=2\n    └── d = 2\n"},
+		{args: "get /a/b", want: "KEY   REVISION  VALUE\n/a/b  2         1\n"},
+		{args: "get /keep", want: "KEY    REVISION  VALUE\n/keep  1         k2\n"},
+
+		// whole namespace
+		{args: "restore FILE", want: "5 keys restored\n"},
+		{args: "ls /copy", err: true},
+		{args: "get /keep", want: "KEY    REVISION  VALUE\n/keep  2         k\n"},
+		{args: "ls rel", want: "rel\n└── x = 3\n"},
+	}
+	for _, step := range steps {
+		c := cfg
+		if step.output != "" {
+			c.output = step.output
+		}
+		args := strings.Fields(strings.Replace(step.args, "FILE", file, -1))
+		out, err := run(t, c, args)
+		if step.err {
+			assert.NotEqual(t, nil, err, step.args)
+			continue
+		}
+		assert.Equal(t, nil, err, step.args)
+		assert.Equal(t, strings.Replace(step.want, "FILE", file, -1), out, step.args)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// entry is a key as get prints it
+type entry struct {
+	Key      string            `json:"key" yaml:"key"`
+	Revision uint32            `json:"revision" yaml:"revision"`
+	Data     map[string]string `json:"data" yaml:"data"`
+}
+
+// printDoc prints v as JSON or YAML document
+func printDoc(cfg *config, v interface{}) error {
+	if cfg.output == "yaml" {
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printEntries(cfg *config, entries []entry) error {
+	if cfg.output != "table" {
+		return printDoc(cfg, entries)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tREVISION\tVALUE\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", e.Key, e.Revision, formatData(e.Data))
+	}
+	return w.Flush()
+}
+
+// formatData shows single value as it is and multi-key value as
+// name=value pairs ordered by name
+func formatData(data map[string]string) string {
+	if v, ok := data["v"]; ok && len(data) == 1 {
+		return v
+	}
+	var names []string
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + data[name]
+	}
+	return strings.Join(pairs, " ")
+}
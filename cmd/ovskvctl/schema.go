@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"../.."
+)
+
+// schemaCmd generates schema for ovsdb-tool create, reports version of
+// the served one or converts it to what ovskv requires
+func schemaCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only tell whether migrate would convert the database")
+	if len(args) == 0 {
+		return fmt.Errorf("schema needs generate, version or migrate")
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+
+	switch action {
+	case "generate":
+		doc, err := ovskv.GenerateSchema(cfg.db, cfg.namespace, cfg.shards, cfg.columns)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", doc)
+
+	case "version":
+		version, err := ovskv.SchemaVersion(cfg.db, cfg.connect)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (required %s)\n", version, ovskv.SCHEMA_VERSION)
+
+	case "migrate":
+		converted, err := ovskv.Migrate(cfg.db, cfg.connect, cfg.namespace, ovskv.MigrateOptions{
+			Shards:  cfg.shards,
+			Columns: cfg.columns,
+			DryRun:  *dryRun,
+		})
+		if err != nil {
+			return err
+		}
+		switch {
+		case !converted:
+			fmt.Println("schema is up to date")
+		case *dryRun:
+			fmt.Println("schema needs conversion")
+		default:
+			fmt.Println("schema converted")
+		}
+
+	default:
+		return fmt.Errorf("unknown schema action %s", action)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"../.."
+)
+
+// snapshotCmd writes snapshot of the namespace into FILE, which is
+// replaced only once the snapshot is complete
+func snapshotCmd(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("snapshot needs FILE")
+	}
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	f, err := ioutil.TempFile(filepath.Dir(args[0]), filepath.Base(args[0])+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := ovs.Snapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d keys saved to %s\n", n, args[0])
+	return nil
+}
+
+// restoreCmd replaces the namespace, or keys under -to, by the snapshot
+func restoreCmd(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := fs.String("from", "", "restore only keys under this key")
+	to := fs.String("to", "", "put the keys under this key instead")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("restore needs FILE")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	n, err := ovs.Restore(f, ovskv.RestoreOptions{From: *from, To: *to})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d keys restored\n", n)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"gopkg.in/yaml.v2"
+)
+
+// event is a watch event as printed in JSON and YAML
+type event struct {
+	Type     string            `json:"type" yaml:"type"`
+	Key      string            `json:"key,omitempty" yaml:"key,omitempty"`
+	Revision uint32            `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Old      map[string]string `json:"old,omitempty" yaml:"old,omitempty"`
+	New      map[string]string `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// watchCmd prints changes of keys under prefix until interrupted, one
+// line per event in table and JSON output, one document in YAML
+func watchCmd(cfg *config, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("watch takes one PREFIX")
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	ovs, err := open(cfg)
+	if err != nil {
+		return err
+	}
+	defer ovs.Disconnect()
+
+	w, err := ovs.Watch(prefix)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case ev, ok := <-w.Events():
+			if !ok {
+				return fmt.Errorf("watch closed")
+			}
+			e := event{Type: ev.Type.String(), Key: ev.Key, Revision: ev.Revision, Old: ev.OldValue, New: ev.NewValue}
+			switch cfg.output {
+			case "json":
+				err = enc.Encode(e)
+			case "yaml":
+				var b []byte
+				if b, err = yaml.Marshal(e); err == nil {
+					_, err = fmt.Printf("---\n%s", b)
+				}
+			default:
+				_, err = fmt.Printf("%s %s %d %s\n", e.Type, e.Key, e.Revision, formatData(e.New))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package ovskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ebay/libovsdb"
+)
+
+// ErrNotLeader is returned by Ping when the server is a member of a Raft
+// cluster which is not its leader, the connection fails over to the next
+// remote then
+var ErrNotLeader = errors.New("ovskv: server is not the cluster leader")
+
+// connHandler watches one connection and starts reconnect when it is lost
+type connHandler struct {
+	o *OvsKVImpl
+	c ovsClient
+}
+
+// Update implements libovsdb.NotificationHandler
+func (h *connHandler) Update(interface{}, libovsdb.TableUpdates) {
+}
+
+// Locked implements libovsdb.NotificationHandler
+func (h *connHandler) Locked([]interface{}) {
+}
+
+// Stolen implements libovsdb.NotificationHandler
+func (h *connHandler) Stolen([]interface{}) {
+}
+
+// Echo implements libovsdb.NotificationHandler
+func (h *connHandler) Echo([]interface{}) {
+}
+
+// Disconnected implements libovsdb.NotificationHandler. Called with
+// libovsdb internal lock held, so reconnect has to happen elsewhere.
+func (h *connHandler) Disconnected(*libovsdb.OvsdbClient) {
+	h.o.disconnected(h.c)
+}
+
+// SetReconnectBackoff sets how long to wait between reconnect attempts,
+// the interval doubles after each failed attempt from min up to max
+func (o *OvsKVImpl) SetReconnectBackoff(min, max time.Duration) {
+	if min <= 0 {
+		min = RECONNECT_INTERVAL
+	}
+	if max < min {
+		max = min
+	}
+	o.mu.Lock()
+	o.backoffMin = min
+	o.backoffMax = max
+	o.mu.Unlock()
+}
+
+// SetDisconnectHook sets function called when connection to ovsdb-server
+// is lost, calls fail until it is re-established
+func (o *OvsKVImpl) SetDisconnectHook(f func()) {
+	o.mu.Lock()
+	o.onDisconnect = f
+	o.mu.Unlock()
+}
+
+// SetReconnectHook sets function called when connection to ovsdb-server
+// is re-established, watchers are resumed by then
+func (o *OvsKVImpl) SetReconnectHook(f func()) {
+	o.mu.Lock()
+	o.onReconnect = f
+	o.mu.Unlock()
+}
+
+// Healthy reports whether there is a connection to ovsdb-server,
+// it does not involve the server, see Ping
+func (o *OvsKVImpl) Healthy() bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.connected && !o.closed
+}
+
+// Ping checks that ovsdb-server serves requests and is the leader if
+// the database is clustered
+func (o *OvsKVImpl) Ping() error {
+	return o.PingCtx(context.Background())
+}
+
+// PingCtx works as Ping, giving up when ctx is done
+func (o *OvsKVImpl) PingCtx(ctx context.Context) error {
+	c := o.client()
+	if !o.Healthy() {
+		return fmt.Errorf("not connected to %s\n", o.db_connect)
+	}
+
+	// select of nothing, just to get a reply
+	op := libovsdb.Operation{
+		Op:      OP_SELECT,
+		Table:   o.shardTable(""),
+		Where:   []interface{}{libovsdb.NewCondition("_uuid", "==", libovsdb.UUID{GoUUID: "00000000-0000-0000-0000-000000000000"})},
+		Columns: []string{"_uuid"},
+	}
+	reply, err := transactCtx(ctx, c, o.db_name, op)
+	if err == nil {
+		err = isTransactError(reply, err, []libovsdb.Operation{op})
+		if err != nil {
+			return err
+		}
+		err = checkLeader(ctx, c, o.db_name)
+	}
+	if err != nil && ctx.Err() == nil {
+		o.failover(c)
+	}
+	return err
+}
+
+// dial connects to the first of comma separated remotes which is the
+// leader of the database, or to in-process database
+func (o *OvsKVImpl) dial() (ovsClient, error) {
+	if strings.HasPrefix(o.db_connect, MEMORY_CONNECT) {
+		return memConnect(o.db_name), nil
+	}
+
+	var errs []string
+	for _, remote := range strings.Split(o.db_connect, ",") {
+		c, err := libovsdb.Connect(remote, nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := checkLeader(context.Background(), c, o.db_name); err != nil {
+			c.Disconnect()
+			errs = append(errs, fmt.Sprintf("%s: %v", remote, err))
+			continue
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("failed to connect to %s: %s\n", o.db_connect, strings.Join(errs, "; "))
+}
+
+// checkLeader asks the server whether it may serve the database, i.e. it
+// is either standalone or the connected leader of the Raft cluster
+func checkLeader(ctx context.Context, c ovsClient, db_name string) error {
+	lc, ok := c.(*libovsdb.OvsdbClient)
+	if !ok {
+		return nil
+	}
+	// servers older than 2.9 have no _Server database and no clustering
+	if _, ok := lc.Schema[SERVER_DB]; !ok {
+		return nil
+	}
+
+	op := libovsdb.Operation{
+		Op:      OP_SELECT,
+		Table:   "Database",
+		Where:   []interface{}{libovsdb.NewCondition("name", "==", db_name)},
+		Columns: []string{"model", "connected", "leader"},
+	}
+	reply, err := transactCtx(ctx, c, SERVER_DB, op)
+	err = isTransactError(reply, err, []libovsdb.Operation{op})
+	if err != nil {
+		return err
+	}
+	if len(reply[0].Rows) == 0 {
+		return fmt.Errorf("database %s not served\n", db_name)
+	}
+	row := reply[0].Rows[0]
+	if row["model"] != "clustered" {
+		return nil
+	}
+	if row["connected"] != true || row["leader"] != true {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// client returns the current connection
+func (o *OvsKVImpl) client() ovsClient {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.ovs
+}
+
+// install makes the client current connection and watches it
+func (o *OvsKVImpl) install(c ovsClient) {
+	o.mu.Lock()
+	o.ovs = c
+	o.connected = true
+	o.mu.Unlock()
+	c.Register(&connHandler{o: o, c: c})
+}
+
+// failover drops connection which is unusable, which starts reconnect
+func (o *OvsKVImpl) failover(c ovsClient) {
+	c.Disconnect()
+	o.disconnected(c)
+}
+
+// disconnected starts reconnect if c is the current connection and it was
+// not started yet
+func (o *OvsKVImpl) disconnected(c ovsClient) {
+	o.mu.Lock()
+	if o.closed || o.ovs != c || !o.connected {
+		o.mu.Unlock()
+		return
+	}
+	o.connected = false
+	hook := o.onDisconnect
+	o.mu.Unlock()
+
+	go func() {
+		if hook != nil {
+			hook()
+		}
+		o.reconnect()
+	}()
+}
+
+// reconnect replaces lost connection to ovsdb-server, retrying with
+// backoff until it succeeds or Disconnect is called
+func (o *OvsKVImpl) reconnect() {
+	o.mu.RLock()
+	backoff := o.backoffMin
+	o.mu.RUnlock()
+
+	for {
+		o.mu.RLock()
+		closed := o.closed
+		max := o.backoffMax
+		o.mu.RUnlock()
+		if closed {
+			return
+		}
+
+		c, err := o.dial()
+		if err == nil {
+			o.mu.Lock()
+			if o.closed {
+				o.mu.Unlock()
+				c.Disconnect()
+				return
+			}
+			watch := o.watch
+			hook := o.onReconnect
+			o.mu.Unlock()
+
+			o.install(c)
+			if watch != nil {
+				if err := watch.monitor(c); err != nil {
+					o.failover(c)
+					return
+				}
+			}
+			if hook != nil {
+				hook()
+			}
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > max {
+			backoff = max
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ovskv
+
+import (
+	"context"
+
+	"github.com/ebay/libovsdb"
+)
+
+// OvsKVCtx is OvsKV with every call bounded by a context, e.g. by deadline
+// of the HTTP request it serves
+type OvsKVCtx interface {
+	InsertKVCtx(ctx context.Context, key, val string) (string, error)
+	InsertKVMCtx(ctx context.Context, key string, val map[string]string) (string, error)
+	SetKVCtx(ctx context.Context, key, val string) (string, error)
+	SetKVMCtx(ctx context.Context, key string, val map[string]string) (string, error)
+	DeleteKVCtx(ctx context.Context, op, key string) (int, error)
+	GetKVCtx(ctx context.Context, op, key string) (OvsKVRows, error)
+	GetKVMCtx(ctx context.Context, op, key string) (*[]libovsdb.ResultRow, error)
+	SaveCtx(ctx context.Context) error
+	SaveFieldCtx(ctx context.Context, field interface{}) error
+	LoadCtx(ctx context.Context) error
+	LoadFieldCtx(ctx context.Context, data interface{}, prefix string) error
+	Disconnect()
+}
+
+// transact sends operations in one transaction and waits for the reply
+// until ctx is done
+func (o *OvsKVImpl) transact(ctx context.Context, ops ...libovsdb.Operation) ([]libovsdb.OperationResult, error) {
+	if o.schemaErr != nil {
+		return nil, o.schemaErr
+	}
+	if !o.renamed() {
+		return transactCtx(ctx, o.client(), o.db_name, ops...)
+	}
+	reply, err := transactCtx(ctx, o.client(), o.db_name, o.renameOps(ops)...)
+	o.renameReply(reply)
+	return reply, err
+}
+
+// transactCtx sends operations to database of the client. libovsdb
+// requests can not be cancelled, so when ctx is done first the transaction
+// is left to complete in background and may still be applied.
+func transactCtx(ctx context.Context, c ovsClient, db_name string, ops ...libovsdb.Operation) ([]libovsdb.OperationResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Done() == nil {
+		return c.Transact(db_name, ops...)
+	}
+
+	type result struct {
+		reply []libovsdb.OperationResult
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		reply, err := c.Transact(db_name, ops...)
+		done <- result{reply, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.reply, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+package ovskv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ebay/libovsdb"
+)
+
+// DeleteOptions controls DeleteTree
+type DeleteOptions struct {
+	DryRun  bool // return keys which would be deleted, delete nothing
+	MaxRows int  // fail with ErrTooManyKeys if there are more keys, 0 for no limit
+}
+
+func (o *OvsKVImpl) DeleteTree(key string, opts DeleteOptions) ([]string, error) {
+	return o.DeleteTreeCtx(context.Background(), key, opts)
+}
+
+// DeleteTreeCtx deletes the key and all keys under it, /a/b takes /a/b/c
+// but not /a/bc. Returns deleted keys in order. Keys are deleted in one
+// transaction which fails with ErrConflict if keys under the key were
+// added or deleted since they were counted. Use DeleteAll to delete
+// everything.
+func (o *OvsKVImpl) DeleteTreeCtx(ctx context.Context, key string, opts DeleteOptions) ([]string, error) {
+	if len(topComponent(key)) == 0 {
+		return nil, fmt.Errorf("DeleteTree of root %q, use DeleteAll\n", key)
+	}
+	pathSet, err := pathFmt(key)
+	if err != nil {
+		return nil, fmt.Errorf("path error: %v\n", err)
+	}
+	condition := libovsdb.NewCondition("path", "includes", pathSet)
+	tables := o.queryTables("includes", key)
+	var ops []libovsdb.Operation
+	for _, table := range tables {
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   table,
+			Where:   []interface{}{condition},
+			Columns: []string{"_uuid", "path"},
+		})
+	}
+	reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for i := range tables {
+		for _, row := range reply[i].Rows {
+			keys = append(keys, pathKey(row["path"]))
+		}
+	}
+	sort.Strings(keys)
+	if opts.MaxRows > 0 && len(keys) > opts.MaxRows {
+		return nil, fmt.Errorf("%w: %d keys under %s, at most %d allowed", ErrTooManyKeys, len(keys), key, opts.MaxRows)
+	}
+	if opts.DryRun || len(keys) == 0 {
+		return keys, nil
+	}
+
+	// rows of each table have to be the ones counted, a table can not be
+	// waited to have no rows, so tables which had none are left alone
+	ops = ops[:0]
+	for i, table := range tables {
+		rows := reply[i].Rows
+		if len(rows) == 0 {
+			continue
+		}
+		expected := make([]map[string]interface{}, len(rows))
+		for j, row := range rows {
+			expected[j] = map[string]interface{}{"_uuid": row["_uuid"]}
+		}
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_WAIT,
+			Table:   table,
+			Timeout: WAIT_TIMEOUT,
+			Where:   []interface{}{condition},
+			Columns: []string{"_uuid"},
+			Until:   "==",
+			Rows:    expected,
+		}, libovsdb.Operation{
+			Op:    OP_DELETE,
+			Table: table,
+			Where: []interface{}{condition},
+		})
+	}
+	opKeys := make([]string, len(ops))
+	for i := range ops {
+		opKeys[i] = key
+	}
+	reply, err = o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, opKeys...)
+	if err != nil {
+		return nil, conflictError(err, map[string]bool{key: true})
+	}
+	return keys, nil
+}
+
+// DeleteAll deletes all keys of the namespace in one transaction. confirm
+// has to be the namespace given to Init, so that it is not called by
+// mistake. Leases are left to expire.
+func (o *OvsKVImpl) DeleteAll(confirm string) (int, error) {
+	return o.DeleteAllCtx(context.Background(), confirm)
+}
+
+// DeleteAllCtx works as DeleteAll, giving up when ctx is done
+func (o *OvsKVImpl) DeleteAllCtx(ctx context.Context, confirm string) (int, error) {
+	if confirm != o.db_namespace {
+		return 0, fmt.Errorf("DeleteAll not confirmed, expected namespace %q got %q\n", o.db_namespace, confirm)
+	}
+	var ops []libovsdb.Operation
+	var keys []string
+	for _, table := range o.shardTables() {
+		ops = append(ops, libovsdb.Operation{
+			Op:    OP_DELETE,
+			Table: table,
+			Where: []interface{}{anyRow},
+		})
+		keys = append(keys, "")
+	}
+	reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := range ops {
+		count += reply[i].Count
+	}
+	return count, nil
+}
+
+// anyRow is condition every row matches. Paths of relative keys do not
+// include the root one and where can not be left empty, libovsdb omits it.
+var anyRow = libovsdb.NewCondition("_uuid", "!=", libovsdb.UUID{GoUUID: "00000000-0000-0000-0000-000000000000"})
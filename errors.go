@@ -0,0 +1,122 @@
+package ovskv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrKeyExists is returned when inserted key already exists
+	ErrKeyExists = errors.New("ovskv: key exists")
+	// ErrNotFound is returned when a key expected to exist does not
+	ErrNotFound = errors.New("ovskv: key not found")
+	// ErrMultiKey is returned when a key expected to be unique matches
+	// several rows
+	ErrMultiKey = errors.New("ovskv: multiple rows for key")
+	// ErrConflict is returned when a key was changed by another writer
+	// since the revision the write was based on
+	ErrConflict = errors.New("ovskv: revision conflict")
+	// ErrLocked is returned by TryLock when the lock is held by another
+	// client
+	ErrLocked = errors.New("ovskv: locked by another client")
+	// ErrNotLocked is returned when the lock a write is fenced with is not
+	// held anymore
+	ErrNotLocked = errors.New("ovskv: lock not held")
+	// ErrTooManyKeys is returned by DeleteTree when the subtree has more
+	// keys than allowed
+	ErrTooManyKeys = errors.New("ovskv: too many keys")
+	// ErrSchema is returned when the database schema does not have
+	// tables and columns as OvsKV is configured to use
+	ErrSchema = errors.New("ovskv: incompatible schema")
+	// ErrSnapshot is returned by Restore when the snapshot is damaged
+	// or of an unknown format
+	ErrSnapshot = errors.New("ovskv: invalid snapshot")
+)
+
+// TransactError is an error ovsdb-server replied to a transaction with.
+// Errors found at commit, e.g. index violation, have Index past the last
+// operation and no Op.
+type TransactError struct {
+	Index    int    // index of the failed operation
+	Op       string // operation, e.g. OP_INSERT
+	Table    string // table of the operation
+	Key      string // key the operation was made for
+	Code     string // OVSDB error, e.g. "constraint violation"
+	Details  string // OVSDB error details
+	Conflict bool   // failed on revision check of the key
+	cause    error  // what failure of guard operation means
+}
+
+func (e *TransactError) Error() string {
+	if e.Conflict {
+		return fmt.Sprintf("%v: %s", ErrConflict, e.Key)
+	}
+	if e.cause != nil {
+		return fmt.Sprintf("%v: %s", e.cause, e.Key)
+	}
+	s := fmt.Sprintf("Transaction Failed due to an error : %v details: %v", e.Code, e.Details)
+	if e.Op != "" {
+		s += fmt.Sprintf(" in: %s %s", e.Op, e.Table)
+	}
+	if e.Key != "" {
+		s += fmt.Sprintf(" key: %s", e.Key)
+	}
+	return s + "\n"
+}
+
+// Is matches ErrKeyExists on unique index violation, ErrConflict on
+// failed revision check and ErrNotLocked on failed fencing
+func (e *TransactError) Is(target error) bool {
+	if e.cause != nil && target == e.cause {
+		return true
+	}
+	switch target {
+	case ErrKeyExists:
+		return e.Code == "constraint violation" && strings.Contains(e.Details, "for index")
+	case ErrConflict:
+		return e.Conflict
+	}
+	return false
+}
+
+// LoadError lists paths Load could not fill, all other fields are loaded
+type LoadError struct {
+	Missing []string         // tagged fields which have no key
+	Invalid map[string]error // keys which could not be parsed into field
+}
+
+func (e *LoadError) missing(path string) {
+	e.Missing = append(e.Missing, path)
+}
+
+func (e *LoadError) invalid(path string, err error) {
+	if e.Invalid == nil {
+		e.Invalid = make(map[string]error)
+	}
+	e.Invalid[path] = err
+}
+
+func (e *LoadError) Error() string {
+	var parts []string
+	if len(e.Missing) > 0 {
+		missing := append([]string{}, e.Missing...)
+		sort.Strings(missing)
+		parts = append(parts, "missing: "+strings.Join(missing, ", "))
+	}
+	if len(e.Invalid) > 0 {
+		var invalid []string
+		for path, err := range e.Invalid {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", path, err))
+		}
+		sort.Strings(invalid)
+		parts = append(parts, "invalid: "+strings.Join(invalid, ", "))
+	}
+	return "ovskv: load failed, " + strings.Join(parts, "; ")
+}
+
+// Is matches ErrNotFound if some of the fields have no key
+func (e *LoadError) Is(target error) bool {
+	return target == ErrNotFound && len(e.Missing) > 0
+}
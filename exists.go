@@ -0,0 +1,72 @@
+package ovskv
+
+import (
+	"context"
+
+	"github.com/ebay/libovsdb"
+)
+
+func (o *OvsKVImpl) Exists(key string) (bool, error) {
+	return o.ExistsCtx(context.Background(), key)
+}
+
+// ExistsCtx returns whether the key exists, only _uuid of the row is
+// transferred
+func (o *OvsKVImpl) ExistsCtx(ctx context.Context, key string) (bool, error) {
+	rows, err := o.selectRows(ctx, "==", key, "_uuid")
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
+
+func (o *OvsKVImpl) Count(op, key string) (int, error) {
+	return o.CountCtx(context.Background(), op, key)
+}
+
+// CountCtx returns number of keys matching op of the key, the same keys
+// GetKV would return, only _uuid of the rows is transferred
+func (o *OvsKVImpl) CountCtx(ctx context.Context, op, key string) (int, error) {
+	rows, err := o.selectRows(ctx, op, key, "_uuid")
+	if err != nil {
+		return 0, err
+	}
+	return len(rows), nil
+}
+
+// IfExists makes Commit fail with ErrNotFound unless the key exists
+func (t *Txn) IfExists(key string) *Txn {
+	return t.pathWait(key, "==", ErrNotFound)
+}
+
+// IfNotExists makes Commit fail with ErrKeyExists if the key exists
+func (t *Txn) IfNotExists(key string) *Txn {
+	return t.pathWait(key, "!=", ErrKeyExists)
+}
+
+// pathWait adds wait for the row of the key to be there, until "==", or
+// not to be there, until "!=". Rows of a table can not be waited to be
+// none, empty rows are left out of the request, but path is unique, so
+// not being the row of the key means there is no row.
+func (t *Txn) pathWait(key, until string, cause error) *Txn {
+	pathSet, err := pathFmt(key)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return t
+	}
+	return t.guard(key, pathWaitOp(t.o.shardTable(key), pathSet, until), cause)
+}
+
+func pathWaitOp(table string, pathSet *libovsdb.OvsSet, until string) libovsdb.Operation {
+	return libovsdb.Operation{
+		Op:      OP_WAIT,
+		Table:   table,
+		Timeout: WAIT_TIMEOUT,
+		Where:   []interface{}{libovsdb.NewCondition("path", "==", pathSet)},
+		Columns: []string{"path"},
+		Until:   until,
+		Rows:    []map[string]interface{}{{"path": pathSet}},
+	}
+}
@@ -0,0 +1,200 @@
+package ovskv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// EXPORT_DATA is the member of an exported object which holds data of the
+// key itself rather than of a child
+const EXPORT_DATA string = "="
+
+// exportNode is a key of the exported subtree
+type exportNode struct {
+	data     map[string]string // nil if the key does not exist
+	children map[string]*exportNode
+}
+
+func (o *OvsKVImpl) Export(prefix string) (interface{}, error) {
+	return o.ExportCtx(context.Background(), prefix)
+}
+
+// ExportCtx returns the subtree of prefix as a document of nested objects
+// which JSON and YAML encode as they are. Keys with children are objects
+// of their children, keys with a single value are the value and keys with
+// multi-key value are objects of just EXPORT_DATA holding their data map:
+//
+//	{"a": {"b": "1", "c": {"=": {"x": "1", "y": "2"}}}}
+//
+// is /a/b with value 1 and /a/c with multi-key value. A key which has both
+// data and children keeps its data under EXPORT_DATA next to the children.
+func (o *OvsKVImpl) ExportCtx(ctx context.Context, prefix string) (interface{}, error) {
+	prefix = strings.TrimSuffix(prefix, SEPA)
+	rows, err := o.selectRows(ctx, "includes", prefix, "path", "data")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 && prefix != "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, prefix)
+	}
+
+	root := &exportNode{}
+	for _, row := range rows {
+		n := root
+		for _, component := range strings.Split(strings.TrimPrefix(pathKey(row["path"]), prefix), SEPA) {
+			if component == "" {
+				continue
+			}
+			if n.children == nil {
+				n.children = make(map[string]*exportNode)
+			}
+			child, ok := n.children[component]
+			if !ok {
+				child = &exportNode{}
+				n.children[component] = child
+			}
+			n = child
+		}
+		n.data = dataMap(row["data"])
+	}
+	if root.children == nil && root.data == nil {
+		return map[string]interface{}{}, nil
+	}
+	return root.doc(), nil
+}
+
+func (n *exportNode) doc() interface{} {
+	if n.children == nil {
+		if v, ok := n.data["v"]; ok && len(n.data) == 1 {
+			return v
+		}
+		return map[string]interface{}{EXPORT_DATA: dataDoc(n.data)}
+	}
+
+	doc := make(map[string]interface{}, len(n.children)+1)
+	for name, child := range n.children {
+		doc[name] = child.doc()
+	}
+	if n.data != nil {
+		if v, ok := n.data["v"]; ok && len(n.data) == 1 {
+			doc[EXPORT_DATA] = v
+		} else {
+			doc[EXPORT_DATA] = dataDoc(n.data)
+		}
+	}
+	return doc
+}
+
+func dataDoc(data map[string]string) map[string]interface{} {
+	doc := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		doc[k] = v
+	}
+	return doc
+}
+
+func (o *OvsKVImpl) Import(prefix string, doc interface{}) error {
+	return o.ImportCtx(context.Background(), prefix, doc)
+}
+
+// ImportCtx sets keys of a document Export returned, or the same decoded
+// from JSON or YAML, under prefix in one transaction. Scalars are stored
+// as single values, numbers and booleans formatted as Save does. Keys not
+// in the document are left as they are.
+func (o *OvsKVImpl) ImportCtx(ctx context.Context, prefix string, doc interface{}) error {
+	t := o.Begin()
+	if err := importDoc(t, strings.TrimSuffix(prefix, SEPA), doc); err != nil {
+		return err
+	}
+	return t.CommitCtx(ctx)
+}
+
+func importDoc(t *Txn, key string, doc interface{}) error {
+	members, ok := docMembers(doc)
+	if !ok {
+		return importData(t, key, doc)
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == EXPORT_DATA {
+			if err := importData(t, key, members[name]); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" || strings.Contains(name, SEPA) {
+			return fmt.Errorf("%s: invalid key name %q\n", key, name)
+		}
+		if err := importDoc(t, key+SEPA+name, members[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importData sets data of the key, given as scalar or object of scalars
+func importData(t *Txn, key string, doc interface{}) error {
+	if key == "" {
+		return fmt.Errorf("root key can not have a value\n")
+	}
+	members, ok := docMembers(doc)
+	if !ok {
+		v, err := scalar(key, doc)
+		if err != nil {
+			return err
+		}
+		t.Set(key, v)
+		return nil
+	}
+
+	data := make(map[string]string, len(members))
+	for name, member := range members {
+		v, err := scalar(key+SEPA+name, member)
+		if err != nil {
+			return err
+		}
+		data[name] = v
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%s: empty data\n", key)
+	}
+	t.SetM(key, data)
+	return nil
+}
+
+// docMembers returns members of an object decoded from JSON or YAML,
+// false if doc is not an object
+func docMembers(doc interface{}) (map[string]interface{}, bool) {
+	switch d := doc.(type) {
+	case map[string]interface{}:
+		return d, true
+	case map[interface{}]interface{}:
+		members := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			members[fmt.Sprintf("%v", k)] = v
+		}
+		return members, true
+	case map[string]string:
+		return dataDoc(d), true
+	}
+	return nil, false
+}
+
+func scalar(key string, doc interface{}) (string, error) {
+	if doc == nil {
+		return "", fmt.Errorf("%s: null value\n", key)
+	}
+	v := reflect.ValueOf(doc)
+	if !isValue(v.Type()) || v.Kind() == reflect.Slice {
+		return "", fmt.Errorf("%s: not supported value %v\n", key, doc)
+	}
+	return formatValue(v)
+}
@@ -0,0 +1,147 @@
+package ovskv
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ebay/libovsdb"
+)
+
+// ListOptions controls List
+type ListOptions struct {
+	Limit    int    // max number of items, 0 for all
+	Continue string // token of the previous page, "" for the first one
+	KeysOnly bool   // leave out the data column
+}
+
+// ListItem is a key returned by List
+type ListItem struct {
+	Key      string
+	UUID     string
+	Revision uint32
+	Data     OvsKVMap // nil if KeysOnly
+}
+
+// ListResult is a page of keys ordered by key
+type ListResult struct {
+	Items    []ListItem
+	Continue string // token of the next page, "" if this is the last one
+}
+
+func (o *OvsKVImpl) List(prefix string, opts ListOptions) (*ListResult, error) {
+	return o.ListCtx(context.Background(), prefix, opts)
+}
+
+// ListCtx returns keys which include prefix, the same keys
+// GetKV("includes", prefix) would return, in lexical order of keys.
+// OVSDB can neither order nor limit select, so the paths of all matching
+// rows are still fetched for every page, KeysOnly saves the transfer of
+// their data.
+func (o *OvsKVImpl) ListCtx(ctx context.Context, prefix string, opts ListOptions) (*ListResult, error) {
+	after, err := decodeContinue(opts.Continue)
+	if err != nil {
+		return nil, err
+	}
+	columns := []string{"_uuid", "path", "revision"}
+	if !opts.KeysOnly {
+		columns = append(columns, "data")
+	}
+	rows, err := o.selectRows(ctx, "includes", prefix, columns...)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]ListItem, 0, len(rows))
+	for _, row := range rows {
+		key := pathKey(row["path"])
+		if opts.Continue != "" && key <= after {
+			continue
+		}
+		item := ListItem{Key: key, UUID: row["_uuid"].(libovsdb.UUID).GoUUID, Revision: rowRevision(row)}
+		if !opts.KeysOnly {
+			item.Data = dataMap(row["data"])
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+	result := &ListResult{Items: items}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		result.Items = items[:opts.Limit]
+		result.Continue = encodeContinue(result.Items[opts.Limit-1].Key)
+	}
+	return result, nil
+}
+
+// continue token is the last key of the page, encoded to keep it opaque
+func encodeContinue(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeContinue(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("Invalid continue token %q\n", token)
+	}
+	return string(key), nil
+}
+
+// Child is an immediate child of the key returned by ListChildren
+type Child struct {
+	Name string
+	Key  string
+	Leaf bool // false if the child has children of its own
+}
+
+func (o *OvsKVImpl) ListChildren(key string) ([]Child, error) {
+	return o.ListChildrenCtx(context.Background(), key)
+}
+
+// ListChildrenCtx returns immediate children of the key ordered by name.
+// Children can not be selected by depth, so paths of the whole subtree
+// are fetched, but not their data.
+func (o *OvsKVImpl) ListChildrenCtx(ctx context.Context, key string) ([]Child, error) {
+	key = strings.TrimSuffix(key, SEPA)
+	rows, err := o.selectRows(ctx, "includes", key, "_uuid", "path")
+	if err != nil {
+		return nil, err
+	}
+
+	// a key which has children of its own is kept as directory, deeper
+	// keys go first so that the directory is there before the key
+	sort.Slice(rows, func(i, j int) bool {
+		return strings.Count(pathKey(rows[i]["path"]), SEPA) > strings.Count(pathKey(rows[j]["path"]), SEPA)
+	})
+	root, err := nodeTree(rows)
+	if err != nil {
+		return nil, err
+	}
+	// nothing is under a key which does not exist or has no children
+	parent, err := walk(root, key, func(prev *node, component string) (*node, error) {
+		if !prev.IsDir() {
+			return nil, ErrNotFound
+		}
+		child, err := prev.GetChild(component)
+		if err == nil && child == nil {
+			err = ErrNotFound
+		}
+		return child, err
+	})
+	if errors.Is(err, ErrNotFound) || (err == nil && !parent.IsDir()) {
+		return []Child{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]Child, 0, len(parent.Children))
+	for name, n := range parent.Children {
+		children = append(children, Child{Name: name, Key: key + SEPA + name, Leaf: !n.IsDir()})
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	return children, nil
+}
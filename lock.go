@@ -0,0 +1,247 @@
+package ovskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ebay/libovsdb"
+)
+
+// Mutex is a lock held by at most one client of the namespace at a time.
+// libovsdb does not implement OVSDB lock, steal and unlock requests nor
+// the assert operation, so the lock is a key attached to a lease of the
+// holder, and writes are fenced by a wait operation which fails the
+// transaction unless the key is still attached to that lease. The lock is
+// released when the holder unlocks it or stops keeping the lease alive,
+// e.g. dies, and its lease gets reaped.
+type Mutex struct {
+	o     *OvsKVImpl
+	key   string
+	ttl   time.Duration
+	value string
+	mu    sync.Mutex
+	lease *Lease
+	done  chan struct{}
+}
+
+// Mutex returns lock stored at the key, holder keeps it alive every third
+// of ttl and it is released ttl after the holder is gone. Leases count in
+// milliseconds, taking the lock fails for ttl under a millisecond.
+func (o *OvsKVImpl) Mutex(key string, ttl time.Duration) *Mutex {
+	return &Mutex{o: o, key: key, ttl: ttl}
+}
+
+// TryLock takes the lock if it is free, otherwise fails with ErrLocked
+func (m *Mutex) TryLock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lease != nil {
+		return nil
+	}
+	if m.ttl < time.Millisecond {
+		return fmt.Errorf("invalid ttl %v of lock %s, at least 1ms needed\n", m.ttl, m.key)
+	}
+
+	lease, err := m.o.Grant(m.ttl)
+	if err != nil {
+		return err
+	}
+	value := m.value
+	if value == "" {
+		value = lease.ID
+	}
+	row, err := newKVRow(m.key, m.o.V(value))
+	if err != nil {
+		return err
+	}
+	row["lease"] = lease.ID
+
+	op := insertOp(m.o.shardTable(m.key), row)
+	reply, err := m.o.transact(context.Background(), op)
+	err = isTransactError(reply, err, []libovsdb.Operation{op}, m.key)
+	if err != nil {
+		m.o.Revoke(lease.ID)
+		if errors.Is(err, ErrKeyExists) {
+			return ErrLocked
+		}
+		return err
+	}
+
+	m.lease = lease
+	m.done = make(chan struct{})
+	go m.keepAlive(lease, m.done)
+	return nil
+}
+
+// Lock waits until the lock is taken or ctx is done. Locks of holders
+// which are gone are reaped meanwhile.
+func (m *Mutex) Lock(ctx context.Context) error {
+	w, err := m.o.Watch(m.key)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for {
+		err := m.TryLock()
+		if !errors.Is(err, ErrLocked) {
+			return err
+		}
+
+		select {
+		case <-w.Events():
+		case <-time.After(m.ttl):
+			if _, err := m.o.ReapCtx(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Unlock releases the lock
+func (m *Mutex) Unlock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lease == nil {
+		return ErrNotLocked
+	}
+	lease := m.lease
+	m.lost()
+
+	if err := m.o.Revoke(lease.ID); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Done returns channel closed when the lock is unlocked or lost, nil if
+// it is not held
+func (m *Mutex) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lease == nil {
+		return nil
+	}
+	return m.done
+}
+
+// Fence makes the transaction fail with ErrNotLocked unless the lock is
+// held at commit
+func (m *Mutex) Fence(t *Txn) *Txn {
+	m.mu.Lock()
+	lease := m.lease
+	m.mu.Unlock()
+	if lease == nil {
+		if t.err == nil {
+			t.err = ErrNotLocked
+		}
+		return t
+	}
+
+	pathSet, err := pathFmt(m.key)
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return t
+	}
+	return t.guard(m.key, libovsdb.Operation{
+		Op:      OP_WAIT,
+		Table:   m.o.shardTable(m.key),
+		Timeout: WAIT_TIMEOUT,
+		Where:   []interface{}{libovsdb.NewCondition("path", "==", pathSet)},
+		Columns: []string{"lease"},
+		Until:   "==",
+		Rows:    []map[string]interface{}{{"lease": lease.ID}},
+	}, ErrNotLocked)
+}
+
+// lost forgets the lease, called with mu held
+func (m *Mutex) lost() {
+	if m.lease == nil {
+		return
+	}
+	m.lease = nil
+	close(m.done)
+}
+
+func (m *Mutex) keepAlive(lease *Lease, done chan struct{}) {
+	ticker := time.NewTicker(lease.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		err := m.o.KeepAlive(lease.ID)
+		if errors.Is(err, ErrNotFound) {
+			m.mu.Lock()
+			if m.lease == lease {
+				m.lost()
+			}
+			m.mu.Unlock()
+			return
+		}
+		m.o.mu.RLock()
+		closed := m.o.closed
+		m.o.mu.RUnlock()
+		if closed {
+			return
+		}
+	}
+}
+
+// Election elects one leader among clients campaigning at the same
+// prefix, it is a Mutex at prefix/leader holding value of the leader
+type Election struct {
+	m *Mutex
+}
+
+// Election returns election at prefix, leader which is gone is replaced
+// ttl after. Campaign fails for ttl under a millisecond.
+func (o *OvsKVImpl) Election(prefix string, ttl time.Duration) *Election {
+	return &Election{m: o.Mutex(prefix+SEPA+"leader", ttl)}
+}
+
+// Campaign waits until this client is elected with value or ctx is done
+func (e *Election) Campaign(ctx context.Context, value string) error {
+	e.m.mu.Lock()
+	e.m.value = value
+	e.m.mu.Unlock()
+	return e.m.Lock(ctx)
+}
+
+// Resign gives up leadership
+func (e *Election) Resign() error {
+	return e.m.Unlock()
+}
+
+// Leader returns value of the current leader, ErrNotFound if there is none
+func (e *Election) Leader() (string, error) {
+	rows, err := e.m.o.GetKVCtx(ServerRead(context.Background()), "==", e.m.key)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", ErrNotFound
+	}
+	return rows[0]["value"], nil
+}
+
+// Done returns channel closed when leadership is given up or lost
+func (e *Election) Done() <-chan struct{} {
+	return e.m.Done()
+}
+
+// Fence makes the transaction fail with ErrNotLocked unless this client
+// is the leader at commit
+func (e *Election) Fence(t *Txn) *Txn {
+	return e.m.Fence(t)
+}
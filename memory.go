@@ -0,0 +1,1093 @@
+package ovskv
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ebay/libovsdb"
+)
+
+// MEMORY_CONNECT selects in-process database instead of ovsdb-server.
+// Databases are shared by name within the process, like ovsdb-server
+// databases are shared by its clients.
+const MEMORY_CONNECT string = "memory:"
+
+var (
+	memDBs   = make(map[string]*memDB)
+	memDBsMu sync.Mutex
+
+	errMemShutdown = errors.New("connection is shut down")
+)
+
+// ovsClient is the part of libovsdb.OvsdbClient used by OvsKVImpl,
+// implemented by the in-memory backend as well
+type ovsClient interface {
+	Transact(database string, operation ...libovsdb.Operation) ([]libovsdb.OperationResult, error)
+	Monitor(database string, jsonContext interface{}, requests map[string]libovsdb.MonitorRequest) (*libovsdb.TableUpdates, error)
+	Register(handler libovsdb.NotificationHandler)
+	Unregister(handler libovsdb.NotificationHandler) error
+	Disconnect()
+}
+
+// NewMemory creates OvsKV on top of in-process database with the same
+// semantics as ovsdb-server with testkv.ovsschema, so OvsKV users can be
+// tested without OVSDB.
+func NewMemory(db_name, db_namespace string, data interface{}) (*OvsKVImpl, error) {
+	return Init(db_name, MEMORY_CONNECT, db_namespace, data)
+}
+
+// MemoryRestart drops all connections to in-process database as if
+// ovsdb-server was restarted, the data is kept
+func MemoryRestart(db_name string) {
+	memDBsMu.Lock()
+	db, ok := memDBs[db_name]
+	memDBsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	db.mu.Lock()
+	var sessions []*memSession
+	for s := range db.sessions {
+		sessions = append(sessions, s)
+	}
+	db.mu.Unlock()
+
+	for _, s := range sessions {
+		s.Disconnect()
+	}
+}
+
+// memColumns are columns of ovskv tables and their default values
+var memColumns = map[string]memDatum{
+	"path":     {},
+	"data":     {isMap: true},
+	"revision": {atoms: []string{"0"}},
+	"expires":  {atoms: []string{"0"}},
+	"lease":    {atoms: []string{`""`}},
+	"ttl":      {atoms: []string{"0"}},
+}
+
+// memIndexes are columns with unique index in key tables
+var memIndexes = []string{"path"}
+
+// memNonEmpty are columns of key tables which hold at least one element
+var memNonEmpty = []string{"path", "data"}
+
+// checkColumns enforces min of key table columns as ovsdb-server does
+func checkColumns(name string, cols map[string]memDatum) *memError {
+	if !memIndexed(name) {
+		return nil
+	}
+	for _, c := range memNonEmpty {
+		if d, ok := cols[c]; ok && len(d.atoms) == 0 {
+			return &memError{"constraint violation", c + " must have at least 1 element"}
+		}
+	}
+	return nil
+}
+
+// memIndexed reports whether table holds keys, as opposed to leases
+func memIndexed(name string) bool {
+	return !strings.HasSuffix(name, LEASE_TABLE)
+}
+
+// memDatum is a column value in canonical form, JSON encoded atoms of a
+// set or keys of a map sorted, with map values kept in vals
+type memDatum struct {
+	isMap bool
+	atoms []string
+	vals  []string
+}
+
+type memRow struct {
+	uuid string
+	cols map[string]memDatum
+}
+
+// memTable keeps rows by UUID and UUIDs of rows by value of each
+// indexed column
+type memTable struct {
+	rows  map[string]*memRow
+	index map[string]map[string]map[string]bool
+}
+
+// memDB is in-process stand-in for ovsdb-server database
+type memDB struct {
+	mu       sync.Mutex
+	notifyMu sync.Mutex
+	tables   map[string]*memTable
+	sessions map[*memSession]bool
+}
+
+// memSession is a connection to memDB
+type memSession struct {
+	db       *memDB
+	mu       sync.Mutex
+	closed   bool
+	handlers []libovsdb.NotificationHandler
+	monitors map[interface{}]map[string]libovsdb.MonitorRequest
+}
+
+type memError struct {
+	err     string
+	details string
+}
+
+func memConnect(db_name string) *memSession {
+	memDBsMu.Lock()
+	db, ok := memDBs[db_name]
+	if !ok {
+		db = &memDB{
+			tables:   make(map[string]*memTable),
+			sessions: make(map[*memSession]bool),
+		}
+		memDBs[db_name] = db
+	}
+	memDBsMu.Unlock()
+
+	s := &memSession{
+		db:       db,
+		monitors: make(map[interface{}]map[string]libovsdb.MonitorRequest),
+	}
+	db.mu.Lock()
+	db.sessions[s] = true
+	db.mu.Unlock()
+	return s
+}
+
+func memUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func memAtom(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func uuidAtom(uuid string) string {
+	return memAtom([]interface{}{"uuid", uuid})
+}
+
+// parseDatum converts OVSDB JSON notation of a value into memDatum
+func parseDatum(v interface{}) (memDatum, error) {
+	var d memDatum
+	if a, ok := v.([]interface{}); ok && len(a) == 2 {
+		switch a[0] {
+		case "set":
+			elems, ok := a[1].([]interface{})
+			if !ok {
+				return d, fmt.Errorf("invalid set %v", v)
+			}
+			for _, e := range elems {
+				d.atoms = append(d.atoms, memAtom(e))
+			}
+			d.sort()
+			return d, nil
+		case "map":
+			pairs, ok := a[1].([]interface{})
+			if !ok {
+				return d, fmt.Errorf("invalid map %v", v)
+			}
+			d.isMap = true
+			m := make(map[string]string)
+			for _, p := range pairs {
+				pair, ok := p.([]interface{})
+				if !ok || len(pair) != 2 {
+					return d, fmt.Errorf("invalid map pair %v", p)
+				}
+				m[memAtom(pair[0])] = memAtom(pair[1])
+			}
+			return memMap(m), nil
+		}
+	}
+	d.atoms = []string{memAtom(v)}
+	return d, nil
+}
+
+func memMap(m map[string]string) memDatum {
+	d := memDatum{isMap: true}
+	for k := range m {
+		d.atoms = append(d.atoms, k)
+	}
+	sort.Strings(d.atoms)
+	for _, k := range d.atoms {
+		d.vals = append(d.vals, m[k])
+	}
+	return d
+}
+
+func (d *memDatum) sort() {
+	sort.Strings(d.atoms)
+	uniq := d.atoms[:0]
+	for i, a := range d.atoms {
+		if i == 0 || a != d.atoms[i-1] {
+			uniq = append(uniq, a)
+		}
+	}
+	d.atoms = uniq
+}
+
+func (d memDatum) toMap() map[string]string {
+	m := make(map[string]string)
+	for i, k := range d.atoms {
+		m[k] = d.vals[i]
+	}
+	return m
+}
+
+// json returns OVSDB JSON notation of the value, single element sets are
+// sent as atoms the same way ovsdb-server does
+func (d memDatum) json() interface{} {
+	if d.isMap {
+		pairs := make([]interface{}, 0, len(d.atoms))
+		for i, k := range d.atoms {
+			pairs = append(pairs, []interface{}{json.RawMessage(k), json.RawMessage(d.vals[i])})
+		}
+		return []interface{}{"map", pairs}
+	}
+	if len(d.atoms) == 1 {
+		return json.RawMessage(d.atoms[0])
+	}
+	atoms := make([]interface{}, 0, len(d.atoms))
+	for _, a := range d.atoms {
+		atoms = append(atoms, json.RawMessage(a))
+	}
+	return []interface{}{"set", atoms}
+}
+
+// String formats the value as ovsdb-server does in error details
+func (d memDatum) String() string {
+	if len(d.atoms) == 1 && !d.isMap {
+		return d.atoms[0]
+	}
+	parts := make([]string, len(d.atoms))
+	for i, a := range d.atoms {
+		parts[i] = a
+		if d.isMap {
+			parts[i] += "=" + d.vals[i]
+		}
+	}
+	if d.isMap {
+		return "{" + strings.Join(parts, ", ") + "}"
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+func (d memDatum) equal(o memDatum) bool {
+	if len(d.atoms) != len(o.atoms) {
+		return false
+	}
+	for i := range d.atoms {
+		if d.atoms[i] != o.atoms[i] {
+			return false
+		}
+		if d.isMap && o.isMap && d.vals[i] != o.vals[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// includes reports whether all elements, or pairs of maps, of o are in d
+func (d memDatum) includes(o memDatum) bool {
+	m := d.toPairs()
+	for k, v := range o.toPairs() {
+		if mv, ok := m[k]; !ok || mv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// excludes reports whether none of elements, or pairs of maps, of o is in d
+func (d memDatum) excludes(o memDatum) bool {
+	m := d.toPairs()
+	for k, v := range o.toPairs() {
+		if mv, ok := m[k]; ok && mv == v {
+			return false
+		}
+	}
+	return true
+}
+
+func (d memDatum) toPairs() map[string]string {
+	m := make(map[string]string)
+	for i, k := range d.atoms {
+		if d.isMap {
+			m[k] = d.vals[i]
+		} else {
+			m[k] = ""
+		}
+	}
+	return m
+}
+
+func (d memDatum) number() (float64, bool) {
+	if len(d.atoms) != 1 || d.isMap {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(d.atoms[0], 64)
+	return f, err == nil
+}
+
+func (r *memRow) get(column string) memDatum {
+	if column == "_uuid" {
+		return memDatum{atoms: []string{uuidAtom(r.uuid)}}
+	}
+	if d, ok := r.cols[column]; ok {
+		return d
+	}
+	return memColumns[column]
+}
+
+func (r *memRow) clone() *memRow {
+	c := &memRow{uuid: r.uuid, cols: make(map[string]memDatum, len(r.cols))}
+	for k, v := range r.cols {
+		c.cols[k] = v
+	}
+	return c
+}
+
+// json returns the row in OVSDB JSON notation limited to columns
+func (r *memRow) json(columns []string) map[string]interface{} {
+	if columns == nil {
+		columns = []string{"_uuid"}
+		for c := range memColumns {
+			columns = append(columns, c)
+		}
+		for c := range r.cols {
+			if _, ok := memColumns[c]; !ok {
+				columns = append(columns, c)
+			}
+		}
+	}
+	row := make(map[string]interface{})
+	for _, c := range columns {
+		if c == "_uuid" {
+			row[c] = []interface{}{"uuid", r.uuid}
+			continue
+		}
+		row[c] = r.get(c).json()
+	}
+	return row
+}
+
+// matches evaluates where clause of an operation on the row
+func (r *memRow) matches(where []interface{}) (bool, *memError) {
+	for _, w := range where {
+		cond, ok := w.([]interface{})
+		if !ok || len(cond) != 3 {
+			return false, &memError{"syntax error", fmt.Sprintf("invalid condition %v", w)}
+		}
+		column, _ := cond[0].(string)
+		function, _ := cond[1].(string)
+		value, err := parseDatum(cond[2])
+		if err != nil {
+			return false, &memError{"syntax error", err.Error()}
+		}
+		d := r.get(column)
+
+		var match bool
+		switch function {
+		case "==":
+			match = d.equal(value)
+		case "!=":
+			match = !d.equal(value)
+		case "includes":
+			match = d.includes(value)
+		case "excludes":
+			match = d.excludes(value)
+		case "<", "<=", ">", ">=":
+			a, aok := d.number()
+			b, bok := value.number()
+			if !aok || !bok {
+				return false, &memError{"syntax error", fmt.Sprintf("%s requires numbers", function)}
+			}
+			switch function {
+			case "<":
+				match = a < b
+			case "<=":
+				match = a <= b
+			case ">":
+				match = a > b
+			case ">=":
+				match = a >= b
+			}
+		default:
+			return false, &memError{"unknown function", function}
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func newMemTable(name string) *memTable {
+	t := &memTable{
+		rows:  make(map[string]*memRow),
+		index: make(map[string]map[string]map[string]bool),
+	}
+	if !memIndexed(name) {
+		return t
+	}
+	for _, column := range memIndexes {
+		t.index[column] = make(map[string]map[string]bool)
+	}
+	return t
+}
+
+// put adds or replaces the row with the same UUID
+func (t *memTable) put(r *memRow) {
+	t.remove(r.uuid)
+	t.rows[r.uuid] = r
+	for column, index := range t.index {
+		key := r.get(column).String()
+		if index[key] == nil {
+			index[key] = make(map[string]bool)
+		}
+		index[key][r.uuid] = true
+	}
+}
+
+func (t *memTable) remove(uuid string) {
+	r, ok := t.rows[uuid]
+	if !ok {
+		return
+	}
+	delete(t.rows, uuid)
+	for column, index := range t.index {
+		key := r.get(column).String()
+		delete(index[key], uuid)
+		if len(index[key]) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// candidates returns rows which may match where, looked up by index
+// if where has equality on indexed column
+func (t *memTable) candidates(where []interface{}) []*memRow {
+	for _, w := range where {
+		cond, ok := w.([]interface{})
+		if !ok || len(cond) != 3 || cond[1] != "==" {
+			continue
+		}
+		column, _ := cond[0].(string)
+		index, ok := t.index[column]
+		if !ok {
+			continue
+		}
+		value, err := parseDatum(cond[2])
+		if err != nil {
+			continue
+		}
+		var rows []*memRow
+		for uuid := range index[value.String()] {
+			rows = append(rows, t.rows[uuid])
+		}
+		return rows
+	}
+	rows := make([]*memRow, 0, len(t.rows))
+	for _, r := range t.rows {
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// memTxn is a transaction in progress, it changes tables in place and
+// keeps rows as they were before the transaction to roll back and to
+// compute monitor updates
+type memTxn struct {
+	db   *memDB
+	undo map[string]map[string]*memRow // nil row if inserted
+}
+
+func (t *memTxn) table(name string) *memTable {
+	table, ok := t.db.tables[name]
+	if !ok {
+		table = newMemTable(name)
+		t.db.tables[name] = table
+	}
+	return table
+}
+
+func (t *memTxn) touch(name, uuid string) {
+	undo, ok := t.undo[name]
+	if !ok {
+		undo = make(map[string]*memRow)
+		t.undo[name] = undo
+	}
+	if _, ok := undo[uuid]; !ok {
+		undo[uuid] = t.table(name).rows[uuid]
+	}
+}
+
+func (t *memTxn) put(name string, r *memRow) {
+	t.touch(name, r.uuid)
+	t.table(name).put(r)
+}
+
+func (t *memTxn) remove(name, uuid string) {
+	t.touch(name, uuid)
+	t.table(name).remove(uuid)
+}
+
+func (t *memTxn) rollback() {
+	for name, undo := range t.undo {
+		table := t.table(name)
+		for uuid, prev := range undo {
+			if prev == nil {
+				table.remove(uuid)
+			} else {
+				table.put(prev)
+			}
+		}
+	}
+}
+
+func (t *memTxn) match(name string, where []interface{}) ([]*memRow, *memError) {
+	var rows []*memRow
+	for _, r := range t.table(name).candidates(where) {
+		ok, err := r.matches(where)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].uuid < rows[j].uuid })
+	return rows, nil
+}
+
+func parseRow(v interface{}) (map[string]memDatum, *memError) {
+	raw, _ := v.(map[string]interface{})
+	cols := make(map[string]memDatum, len(raw))
+	for c, val := range raw {
+		d, err := parseDatum(val)
+		if err != nil {
+			return nil, &memError{"syntax error", err.Error()}
+		}
+		cols[c] = d
+	}
+	return cols, nil
+}
+
+func (t *memTxn) execute(op map[string]interface{}) (map[string]interface{}, *memError) {
+	name, _ := op["table"].(string)
+	where, _ := op["where"].([]interface{})
+	result := make(map[string]interface{})
+
+	switch op["op"] {
+	case OP_INSERT:
+		cols, err := parseRow(op["row"])
+		if err == nil {
+			err = checkColumns(name, cols)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := &memRow{uuid: memUUID(), cols: cols}
+		t.put(name, r)
+		result["uuid"] = []interface{}{"uuid", r.uuid}
+
+	case OP_SELECT:
+		rows, err := t.match(name, where)
+		if err != nil {
+			return nil, err
+		}
+		var columns []string
+		if cs, ok := op["columns"].([]interface{}); ok {
+			for _, c := range cs {
+				columns = append(columns, c.(string))
+			}
+		}
+		out := make([]interface{}, 0, len(rows))
+		for _, r := range rows {
+			out = append(out, r.json(columns))
+		}
+		result["rows"] = out
+
+	case OP_UPDATE:
+		cols, err := parseRow(op["row"])
+		if err == nil {
+			err = checkColumns(name, cols)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows, err := t.match(name, where)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			c := r.clone()
+			for k, v := range cols {
+				c.cols[k] = v
+			}
+			t.put(name, c)
+		}
+		result["count"] = len(rows)
+
+	case OP_MUTATE:
+		rows, err := t.match(name, where)
+		if err != nil {
+			return nil, err
+		}
+		mutations, _ := op["mutations"].([]interface{})
+		for _, r := range rows {
+			c := r.clone()
+			for _, m := range mutations {
+				if err := c.mutate(m); err != nil {
+					return nil, err
+				}
+			}
+			t.put(name, c)
+		}
+		result["count"] = len(rows)
+
+	case OP_DELETE:
+		rows, err := t.match(name, where)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			t.remove(name, r.uuid)
+		}
+		result["count"] = len(rows)
+
+	case OP_WAIT:
+		// there is nobody to wait for, so timeout right away
+		rows, err := t.match(name, where)
+		if err != nil {
+			return nil, err
+		}
+		var columns []string
+		if cs, ok := op["columns"].([]interface{}); ok {
+			for _, c := range cs {
+				columns = append(columns, c.(string))
+			}
+		}
+		expected, _ := op["rows"].([]interface{})
+		var have, want []string
+		for _, r := range rows {
+			var parts []string
+			for _, c := range columns {
+				parts = append(parts, c+"="+r.get(c).String())
+			}
+			have = append(have, strings.Join(parts, ","))
+		}
+		for _, e := range expected {
+			cols, err := parseRow(e)
+			if err != nil {
+				return nil, err
+			}
+			var parts []string
+			for _, c := range columns {
+				d, ok := cols[c]
+				if !ok {
+					d = memColumns[c]
+				}
+				parts = append(parts, c+"="+d.String())
+			}
+			want = append(want, strings.Join(parts, ","))
+		}
+		sort.Strings(have)
+		sort.Strings(want)
+		equal := strings.Join(have, "\n") == strings.Join(want, "\n") && len(have) == len(want)
+		if (op["until"] == "==") != equal {
+			return nil, &memError{"timed out", ""}
+		}
+
+	case "comment":
+
+	case "abort":
+		return nil, &memError{"aborted", ""}
+
+	default:
+		return nil, &memError{"unknown operation", fmt.Sprintf("%v", op["op"])}
+	}
+	return result, nil
+}
+
+func (r *memRow) mutate(m interface{}) *memError {
+	mutation, ok := m.([]interface{})
+	if !ok || len(mutation) != 3 {
+		return &memError{"syntax error", fmt.Sprintf("invalid mutation %v", m)}
+	}
+	column, _ := mutation[0].(string)
+	mutator, _ := mutation[1].(string)
+	value, err := parseDatum(mutation[2])
+	if err != nil {
+		return &memError{"syntax error", err.Error()}
+	}
+	d := r.get(column)
+
+	switch mutator {
+	case "+=", "-=", "*=", "/=", "%=":
+		a, aok := d.number()
+		b, bok := value.number()
+		if !aok || !bok {
+			return &memError{"syntax error", fmt.Sprintf("%s requires numbers", mutator)}
+		}
+		switch mutator {
+		case "+=":
+			a += b
+		case "-=":
+			a -= b
+		case "*=":
+			a *= b
+		case "/=", "%=":
+			if b == 0 {
+				return &memError{"domain error", "division by zero"}
+			}
+			if mutator == "/=" {
+				a = float64(int64(a) / int64(b))
+			} else {
+				a = float64(int64(a) % int64(b))
+			}
+		}
+		d = memDatum{atoms: []string{memAtom(a)}}
+
+	case "insert":
+		if d.isMap {
+			m := d.toMap()
+			for i, k := range value.atoms {
+				if _, ok := m[k]; !ok {
+					m[k] = value.vals[i]
+				}
+			}
+			d = memMap(m)
+		} else {
+			n := memDatum{atoms: append(append([]string{}, d.atoms...), value.atoms...)}
+			n.sort()
+			d = n
+		}
+
+	case "delete":
+		if d.isMap {
+			m := d.toMap()
+			for i, k := range value.atoms {
+				if !value.isMap || m[k] == value.vals[i] {
+					delete(m, k)
+				}
+			}
+			d = memMap(m)
+		} else {
+			del := value.toPairs()
+			n := memDatum{}
+			for _, a := range d.atoms {
+				if _, ok := del[a]; !ok {
+					n.atoms = append(n.atoms, a)
+				}
+			}
+			d = n
+		}
+
+	default:
+		return &memError{"syntax error", fmt.Sprintf("unknown mutator %s", mutator)}
+	}
+	r.cols[column] = d
+	return nil
+}
+
+// checkIndexes reports rows of the transaction violating unique indexes
+func (t *memTxn) checkIndexes() *memError {
+	names := make([]string, 0, len(t.undo))
+	for name := range t.undo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		table := t.table(name)
+		uuids := make([]string, 0, len(t.undo[name]))
+		for uuid := range t.undo[name] {
+			uuids = append(uuids, uuid)
+		}
+		sort.Strings(uuids)
+		for _, uuid := range uuids {
+			r, ok := table.rows[uuid]
+			if !ok {
+				continue
+			}
+			for column := range table.index {
+				d := r.get(column)
+				var first string
+				for other := range table.index[column][d.String()] {
+					if other != uuid && (first == "" || other < first) {
+						first = other
+					}
+				}
+				if first == "" {
+					continue
+				}
+				return &memError{"constraint violation", fmt.Sprintf(
+					"Transaction causes multiple rows in \"%s\" table to have identical values (%s) for index on column \"%s\".  "+
+						"First row, with UUID %s, %s.  Second row, with UUID %s, %s.",
+					name, d, column, first, t.origin(name, first), uuid, t.origin(name, uuid))}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *memTxn) origin(name, uuid string) string {
+	prev, ok := t.undo[name][uuid]
+	if !ok {
+		return "existed in the database before this transaction and was not modified by the transaction"
+	}
+	if prev == nil {
+		return "was inserted by this transaction"
+	}
+	return "existed in the database before this transaction and was modified by the transaction"
+}
+
+// Transact executes operations atomically, failures are reported in
+// operation results the same way as by ovsdb-server
+func (s *memSession) Transact(database string, operation ...libovsdb.Operation) ([]libovsdb.OperationResult, error) {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, errMemShutdown
+	}
+
+	// operate on JSON notation, as ovsdb-server sees it
+	b, err := json.Marshal(operation)
+	if err != nil {
+		return nil, err
+	}
+	var ops []map[string]interface{}
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, err
+	}
+
+	db := s.db
+	db.mu.Lock()
+	txn := &memTxn{db: db, undo: make(map[string]map[string]*memRow)}
+
+	results := make([]interface{}, len(ops))
+	var failed *memError
+	for i, op := range ops {
+		res, err := txn.execute(op)
+		if err != nil {
+			results[i] = map[string]interface{}{"error": err.err, "details": err.details}
+			failed = err
+			break
+		}
+		results[i] = res
+	}
+	if failed == nil {
+		if err := txn.checkIndexes(); err != nil {
+			results = append(results, map[string]interface{}{"error": err.err, "details": err.details})
+			failed = err
+		}
+	}
+
+	var updates []memUpdate
+	if failed == nil {
+		updates = db.updates(txn)
+	} else {
+		txn.rollback()
+	}
+	db.notifyMu.Lock()
+	db.mu.Unlock()
+	for _, u := range updates {
+		u.deliver()
+	}
+	db.notifyMu.Unlock()
+
+	b, err = json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	var reply []libovsdb.OperationResult
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// memUpdate is an update notification for one monitor of a session
+type memUpdate struct {
+	context  interface{}
+	handlers []libovsdb.NotificationHandler
+	updates  map[string]map[string]interface{}
+}
+
+func (u memUpdate) deliver() {
+	updates, err := memTableUpdates(u.updates)
+	if err != nil {
+		return
+	}
+	for _, h := range u.handlers {
+		h.Update(u.context, updates)
+	}
+}
+
+func memTableUpdates(raw map[string]map[string]interface{}) (libovsdb.TableUpdates, error) {
+	updates := libovsdb.TableUpdates{Updates: make(map[string]libovsdb.TableUpdate)}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return updates, err
+	}
+	var rows map[string]map[string]libovsdb.RowUpdate
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return updates, err
+	}
+	for table, update := range rows {
+		updates.Updates[table] = libovsdb.TableUpdate{Rows: update}
+	}
+	return updates, nil
+}
+
+// updates computes notifications of the transaction for all monitors
+func (db *memDB) updates(txn *memTxn) []memUpdate {
+	var res []memUpdate
+	for s := range db.sessions {
+		s.mu.Lock()
+		for context, requests := range s.monitors {
+			raw := make(map[string]map[string]interface{})
+			for name, req := range requests {
+				if _, ok := txn.undo[name]; !ok {
+					continue
+				}
+				rows := memDiff(txn.undo[name], db.tables[name], req)
+				if len(rows) > 0 {
+					raw[name] = rows
+				}
+			}
+			if len(raw) > 0 {
+				handlers := append([]libovsdb.NotificationHandler{}, s.handlers...)
+				res = append(res, memUpdate{context: context, handlers: handlers, updates: raw})
+			}
+		}
+		s.mu.Unlock()
+	}
+	return res
+}
+
+// memDiff returns row updates of the transaction given rows it touched
+// as they were before it
+func memDiff(undo map[string]*memRow, table *memTable, req libovsdb.MonitorRequest) map[string]interface{} {
+	rows := make(map[string]interface{})
+	for uuid, prev := range undo {
+		r := table.rows[uuid]
+		switch {
+		case prev == nil && r == nil:
+		case prev == nil:
+			if req.Select.Insert {
+				rows[uuid] = map[string]interface{}{"new": r.json(req.Columns)}
+			}
+		case r == nil:
+			if req.Select.Delete {
+				rows[uuid] = map[string]interface{}{"old": prev.json(req.Columns)}
+			}
+		case req.Select.Modify:
+			changed := make(map[string]interface{})
+			for c := range r.json(req.Columns) {
+				if c != "_uuid" && !prev.get(c).equal(r.get(c)) {
+					changed[c] = prev.get(c).json()
+				}
+			}
+			if len(changed) > 0 {
+				rows[uuid] = map[string]interface{}{"new": r.json(req.Columns), "old": changed}
+			}
+		}
+	}
+	return rows
+}
+
+// Monitor registers monitor of the session and returns initial rows
+func (s *memSession) Monitor(database string, jsonContext interface{}, requests map[string]libovsdb.MonitorRequest) (*libovsdb.TableUpdates, error) {
+	db := s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errMemShutdown
+	}
+	if _, ok := s.monitors[jsonContext]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("duplicate monitor ID")
+	}
+	s.monitors[jsonContext] = requests
+	s.mu.Unlock()
+
+	raw := make(map[string]map[string]interface{})
+	for name, req := range requests {
+		if !req.Select.Initial {
+			continue
+		}
+		rows := make(map[string]interface{})
+		table, ok := db.tables[name]
+		if !ok {
+			continue
+		}
+		for uuid, r := range table.rows {
+			rows[uuid] = map[string]interface{}{"new": r.json(req.Columns)}
+		}
+		if len(rows) > 0 {
+			raw[name] = rows
+		}
+	}
+	updates, err := memTableUpdates(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &updates, nil
+}
+
+// Register implements ovsClient
+func (s *memSession) Register(handler libovsdb.NotificationHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
+}
+
+// Unregister implements ovsClient
+func (s *memSession) Unregister(handler libovsdb.NotificationHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, h := range s.handlers {
+		if h == handler {
+			s.handlers = append(s.handlers[:i], s.handlers[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("Handler not found")
+}
+
+// Disconnect closes the session and notifies its handlers
+func (s *memSession) Disconnect() {
+	s.db.mu.Lock()
+	delete(s.db.sessions, s)
+	s.db.mu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	handlers := s.handlers
+	s.mu.Unlock()
+
+	for _, h := range handlers {
+		h.Disconnected(nil)
+	}
+}
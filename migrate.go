@@ -0,0 +1,348 @@
+package ovskv
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ebay/libovsdb"
+)
+
+// SCHEMA_VERSION is the version of the schema OvsKV requires, 1.1.0 added
+// revision column, 1.2.0 shard tables, 1.3.0 expires and lease columns and
+// the lease table
+const SCHEMA_VERSION string = "1.3.0"
+
+// MigrateOptions tell Migrate which tables are required
+type MigrateOptions struct {
+	Shards  int     // number of shard tables, at least 1
+	Columns Columns // as given to SetColumns
+	DryRun  bool    // only report whether conversion is needed
+}
+
+// GenerateSchema returns schema document of database db_name with shard
+// tables under db_namespace and the lease table, as ovsdb-tool create
+// takes it
+func GenerateSchema(db_name, db_namespace string, shards int, columns Columns) ([]byte, error) {
+	columns, err := normalizeColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
+	tables := make(map[string]interface{})
+	for i := 1; i <= shards; i++ {
+		tables[db_namespace+strconv.Itoa(i)] = shardTableSchema(columns)
+	}
+	tables[db_namespace+LEASE_TABLE] = map[string]interface{}{
+		"columns": map[string]interface{}{
+			"ttl":     map[string]interface{}{"type": "integer"},
+			"expires": map[string]interface{}{"type": "integer"},
+		},
+		"isRoot": true,
+	}
+	return json.MarshalIndent(map[string]interface{}{
+		"name":    db_name,
+		"version": SCHEMA_VERSION,
+		"tables":  tables,
+	}, "", "  ")
+}
+
+// shardTableSchema returns schema of a shard table, rows are not referenced
+// from anywhere so the table has to be root
+func shardTableSchema(columns Columns) map[string]interface{} {
+	schema := map[string]interface{}{
+		columns.Path: map[string]interface{}{"type": map[string]interface{}{"key": "string", "min": 1, "max": "unlimited"}},
+		columns.Data: map[string]interface{}{"type": map[string]interface{}{"key": "string", "value": "string", "min": 1, "max": "unlimited"}},
+		"revision":   map[string]interface{}{"type": "integer"},
+		"expires":    map[string]interface{}{"type": "integer"},
+		"lease":      map[string]interface{}{"type": "string"},
+	}
+	for name, typ := range columns.Extra {
+		schema[name] = map[string]interface{}{"type": typ}
+	}
+	return map[string]interface{}{
+		"columns": schema,
+		"indexes": [][]string{{columns.Path}},
+		"isRoot":  true,
+	}
+}
+
+// SchemaVersion returns version of database db_name as served at
+// db_connect, in-process database is always at SCHEMA_VERSION
+func SchemaVersion(db_name, db_connect string) (string, error) {
+	return SchemaVersionCtx(context.Background(), db_name, db_connect)
+}
+
+// SchemaVersionCtx works as SchemaVersion, giving up when ctx is done
+func SchemaVersionCtx(ctx context.Context, db_name, db_connect string) (string, error) {
+	if strings.HasPrefix(db_connect, MEMORY_CONNECT) {
+		return SCHEMA_VERSION, nil
+	}
+
+	var errs []string
+	for _, remote := range strings.Split(db_connect, ",") {
+		r, err := rpcDial(ctx, remote)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		var schema struct {
+			Version string `json:"version"`
+		}
+		err = r.call(ctx, &schema, "get_schema", db_name)
+		r.close()
+		if err != nil {
+			return "", err
+		}
+		return schema.Version, nil
+	}
+	return "", fmt.Errorf("failed to connect to %s: %s\n", db_connect, strings.Join(errs, "; "))
+}
+
+// Migrate converts database db_name online so it has the tables, columns
+// and indexes OvsKV requires, e.g. after upgrade to a version which needs
+// new columns. Tables, columns and rows it does not need are kept, so are
+// compatible columns of other types. Returns whether the schema was, or
+// with DryRun would be, converted.
+//
+// ovsdb-server drops connections to the database when it is converted,
+// OvsKVImpl reconnects by itself.
+func Migrate(db_name, db_connect, db_namespace string, opts MigrateOptions) (bool, error) {
+	return MigrateCtx(context.Background(), db_name, db_connect, db_namespace, opts)
+}
+
+// MigrateCtx works as Migrate, giving up when ctx is done
+func MigrateCtx(ctx context.Context, db_name, db_connect, db_namespace string, opts MigrateOptions) (bool, error) {
+	doc, err := GenerateSchema(db_name, db_namespace, opts.Shards, opts.Columns)
+	if err != nil {
+		return false, err
+	}
+	// in-process database has any table asked for
+	if strings.HasPrefix(db_connect, MEMORY_CONNECT) {
+		return false, nil
+	}
+
+	remote, err := leaderRemote(ctx, db_name, db_connect)
+	if err != nil {
+		return false, err
+	}
+	r, err := rpcDial(ctx, remote)
+	if err != nil {
+		return false, err
+	}
+	defer r.close()
+
+	var schema map[string]interface{}
+	if err := r.call(ctx, &schema, "get_schema", db_name); err != nil {
+		return false, err
+	}
+	var required map[string]interface{}
+	if err := json.Unmarshal(doc, &required); err != nil {
+		return false, err
+	}
+	if !mergeTables(schema, required["tables"].(map[string]interface{})) {
+		return false, nil
+	}
+	if version, _ := schema["version"].(string); versionLess(version, SCHEMA_VERSION) {
+		schema["version"] = SCHEMA_VERSION
+	}
+	// checksum of the old schema does not match anymore
+	delete(schema, "cksum")
+	if opts.DryRun {
+		return true, nil
+	}
+
+	if err := r.call(ctx, nil, "convert", db_name, schema); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mergeTables adds required tables to the schema, and required columns
+// and indexes to its tables unless they have them already, both given as
+// decoded JSON. Returns whether the schema changed.
+func mergeTables(schema map[string]interface{}, required map[string]interface{}) bool {
+	tables, ok := schema["tables"].(map[string]interface{})
+	if !ok {
+		tables = make(map[string]interface{})
+		schema["tables"] = tables
+	}
+
+	changed := false
+	for name, t := range required {
+		want := t.(map[string]interface{})
+		have, ok := tables[name].(map[string]interface{})
+		if !ok {
+			tables[name] = want
+			changed = true
+			continue
+		}
+
+		columns, ok := have["columns"].(map[string]interface{})
+		if !ok {
+			columns = make(map[string]interface{})
+			have["columns"] = columns
+		}
+		for column, c := range want["columns"].(map[string]interface{}) {
+			if old, ok := columns[column].(map[string]interface{}); ok &&
+				parseColumnType(old["type"]).compatible(parseColumnType(c.(map[string]interface{})["type"])) {
+				continue
+			}
+			columns[column] = c
+			changed = true
+		}
+
+		indexes, _ := have["indexes"].([]interface{})
+		wantIndexes, _ := want["indexes"].([]interface{})
+		for _, index := range wantIndexes {
+			if !containsIndex(indexes, index) {
+				indexes = append(indexes, index)
+				changed = true
+			}
+		}
+		if len(indexes) > 0 {
+			have["indexes"] = indexes
+		}
+
+		if have["isRoot"] != true {
+			have["isRoot"] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func containsIndex(indexes []interface{}, index interface{}) bool {
+	for _, i := range indexes {
+		if reflect.DeepEqual(i, index) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionLess compares "x.y.z" schema versions
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// leaderRemote returns the first of comma separated remotes which may
+// serve the database, see checkLeader
+func leaderRemote(ctx context.Context, db_name, db_connect string) (string, error) {
+	var errs []string
+	for _, remote := range strings.Split(db_connect, ",") {
+		c, err := libovsdb.Connect(remote, nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		err = checkLeader(ctx, c, db_name)
+		c.Disconnect()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", remote, err))
+			continue
+		}
+		return remote, nil
+	}
+	return "", fmt.Errorf("failed to connect to %s: %s\n", db_connect, strings.Join(errs, "; "))
+}
+
+// rpcConn is a plain JSON-RPC connection to ovsdb-server for methods
+// libovsdb does not have, e.g. convert
+type rpcConn struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	id   int
+}
+
+// rpcDial connects to tcp:, unix: or ssl: remote, the last one with
+// default TLS configuration as Init connects with
+func rpcDial(ctx context.Context, remote string) (*rpcConn, error) {
+	parts := strings.SplitN(remote, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("remote %s not supported\n", remote)
+	}
+	var d net.Dialer
+	var conn net.Conn
+	var err error
+	switch parts[0] {
+	case "tcp", "unix":
+		conn, err = d.DialContext(ctx, parts[0], parts[1])
+	case "ssl":
+		conn, err = (&tls.Dialer{NetDialer: &d}).DialContext(ctx, "tcp", parts[1])
+	default:
+		return nil, fmt.Errorf("remote %s not supported\n", remote)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rpcConn{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+}
+
+func (r *rpcConn) close() {
+	r.conn.Close()
+}
+
+// call sends request and decodes result of its reply into result unless
+// it is nil, answering echo requests of the server meanwhile
+func (r *rpcConn) call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.conn.Close()
+		case <-stop:
+		}
+	}()
+
+	r.id++
+	err := r.enc.Encode(map[string]interface{}{"method": method, "params": params, "id": r.id})
+	for err == nil {
+		var msg struct {
+			ID     interface{}     `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err = r.dec.Decode(&msg); err != nil {
+			break
+		}
+		if msg.Method == "echo" {
+			err = r.enc.Encode(map[string]interface{}{"result": msg.Params, "error": nil, "id": msg.ID})
+			continue
+		}
+		if msg.Method != "" || msg.ID != float64(r.id) {
+			continue
+		}
+		if len(msg.Error) > 0 && string(msg.Error) != "null" {
+			return fmt.Errorf("%s failed: %s\n", method, msg.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
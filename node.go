@@ -56,6 +56,41 @@ func (n *node) Value() string {
 	return (*n.Data)["data"].(libovsdb.OvsMap).GoMap["v"].(string)
 }
 
+// value returns single value of the key, false for directories and
+// multi-key values
+func (n *node) value() (string, bool) {
+	m, ok := n.stringMap()
+	if !ok {
+		return "", false
+	}
+	v, ok := m["v"]
+	return v, ok
+}
+
+// stringMap returns data map of the key, false for directories
+func (n *node) stringMap() (map[string]string, bool) {
+	if n.Data == nil {
+		return nil, false
+	}
+	data, ok := (*n.Data)["data"].(libovsdb.OvsMap)
+	if !ok {
+		return nil, false
+	}
+	m := make(map[string]string, len(data.GoMap))
+	for k, v := range data.GoMap {
+		m[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+	}
+	return m, true
+}
+
+// Revision returns revision of the row, zero for directories
+func (n *node) Revision() uint32 {
+	if n.Data == nil {
+		return 0
+	}
+	return rowRevision(*n.Data)
+}
+
 func (n *node) Key() string {
 	if n.IsDir() {
 		return n.Path
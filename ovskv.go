@@ -1,11 +1,16 @@
 package ovskv
 
 import (
+	"context"
 	"fmt"
+	"hash/fnv"
 	"path"
 	"strings"
 	"strconv"
 	"reflect"
+	"regexp"
+	"sync"
+	"time"
 
 	"github.com/ebay/libovsdb"
 )
@@ -15,23 +20,33 @@ const (
 	OP_UPDATE string = "update"
 	OP_DELETE string = "delete"
 	OP_SELECT string = "select"
+	OP_MUTATE string = "mutate"
+	OP_WAIT string = "wait"
 	SEPA string = "/"
 	OVSSET_SEPA string = ";"
 	OVSKV_TAG string = "ovskv"
 	OVSKV_UUID string = "_uuid"
+	RECONNECT_INTERVAL time.Duration = time.Second
+	RECONNECT_MAX_INTERVAL time.Duration = 30 * time.Second
+	SERVER_DB string = "_Server"
+	// wait operation timeout in ms, zero would mean wait forever
+	// as libovsdb omits it
+	WAIT_TIMEOUT int = 1
 )
 
+var quotedRe = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+
 type OvsKVRow map[string]interface{}
 type OvsKVMap map[string]string
 type OvsKVRows []OvsKVMap
 
 type OvsKV interface {
-	InsertKV(key, val string)  (string, error)
-	InsertKVM(key string, val map[string]string)
-	SetKV(key, val string)  (string, error)
-	SetKVM(key string, val map[string]string)
+	InsertKV(key, val string) (string, error)
+	InsertKVM(key string, val map[string]string) (string, error)
+	SetKV(key, val string) (string, error)
+	SetKVM(key string, val map[string]string) (string, error)
 	DeleteKV(op, key string) (int, error)
-	GetKV(op, key string) (OvsKVRow, error)
+	GetKV(op, key string) (OvsKVRows, error)
 	GetKVM(op, key string) (*[]libovsdb.ResultRow, error)
 	Save() error
 	SaveField(field interface{}) error
@@ -40,13 +55,35 @@ type OvsKV interface {
 	Disconnect()
 }
 
+// OvsKVImpl, the ovsdb one and the in-memory one NewMemory returns alike,
+// is what OvsKV and OvsKVCtx users get
+var (
+	_ OvsKV    = (*OvsKVImpl)(nil)
+	_ OvsKVCtx = (*OvsKVImpl)(nil)
+)
+
 type OvsKVImpl struct {
 	db_name      string
 	db_connect   string
 	db_namespace string
-	ovs          *libovsdb.OvsdbClient
+	ovs          ovsClient
 	info         map[string]info
 	data         reflect.Value
+	shards       int
+	shardKey     func(key string) string
+	columns      Columns
+	schemaErr    error // set until SetColumns maps custom columns
+	allowMissing bool
+	cache        bool
+	reaping      bool
+	mu           sync.RWMutex
+	closed       bool
+	connected    bool
+	backoffMin   time.Duration
+	backoffMax   time.Duration
+	onDisconnect func()
+	onReconnect  func()
+	watch        *watchHub
 }
 
 // to keep introspected data
@@ -55,48 +92,181 @@ type info struct {
 	version uint32
 }
 
-func isTransactError(reply []libovsdb.OperationResult, err error, operations ...[]libovsdb.Operation) error {
+// isTransactError returns *TransactError of the first failed operation,
+// ovsdb-server does not execute operations past it
+func isTransactError(reply []libovsdb.OperationResult, err error, operations []libovsdb.Operation, keys ...string) error {
 	if err != nil {
 		return err
 	}
-	errStr := ""
-	if len(reply) < len(operations) {
-		errStr = fmt.Sprintf("Number of Replies should be atleast equal to number of Operations\n")
-	}
-	fail := false
-	if len(reply) > 0 {
-		for i, o := range reply {
-			if o.Error != "" && i < len(operations) {
-				errStr += fmt.Sprintf("Transaction Failed due to an error : %v details: %v in: %v\n", o.Error, o.Details, operations[i])
-				fail = true
-			} else if o.Error != "" {
-				errStr += fmt.Sprintf("Transaction Failed due to an error : %v\n", o.Error)
-				fail = true
+	for i, o := range reply {
+		if o.Error == "" {
+			continue
+		}
+		terr := &TransactError{Index: i, Code: o.Error, Details: o.Details}
+		if i < len(operations) {
+			terr.Op = operations[i].Op
+			terr.Table = operations[i].Table
+			if i < len(keys) {
+				terr.Key = keys[i]
+			}
+		} else if key, ok := conflictKey(o.Details, keys); ok {
+			// commit time errors, e.g. index violation, are reported
+			// past the last operation, so find the key from details
+			terr.Key = key
+		}
+		return terr
+	}
+	if len(reply) == 0 || len(reply) < len(operations) {
+		return fmt.Errorf("Number of Replies should be atleast equal to number of Operations\n")
+	}
+	return nil
+}
+
+// conflictKey finds which of the keys has its path Set quoted in OVSDB
+// error details, e.g. "identical values (["0;", "1;a"]) for index"
+func conflictKey(details string, keys []string) (string, bool) {
+	start := strings.Index(details, "(")
+	end := strings.Index(details, ")")
+	if start < 0 || end < start {
+		return "", false
+	}
+	quoted := make(map[string]bool)
+	for _, q := range quotedRe.FindAllString(details[start:end], -1) {
+		if s, err := strconv.Unquote(q); err == nil {
+			quoted[s] = true
+		}
+	}
+	for _, key := range keys {
+		parts := pathParts(key)
+		if len(parts) != len(quoted) {
+			continue
+		}
+		match := true
+		for _, p := range parts {
+			if !quoted[p] {
+				match = false
+				break
 			}
 		}
-	} else {
-		fail = true
+		if match {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// shardTable selects one of Zone_1..Zone_N tables for the key by hashing its
+// shard key, which is the top level path component unless set by
+// SetShardKeyFunc
+func (o *OvsKVImpl) shardTable(key string) string {
+	if o.shards <= 1 {
+		return o.db_namespace + "1"
+	}
+	shardKey := topComponent(key)
+	if o.shardKey != nil {
+		shardKey = o.shardKey(key)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(shardKey))
+	return o.db_namespace + strconv.Itoa(int(h.Sum32()%uint32(o.shards))+1)
+}
+
+// shardTables returns all shard tables of the namespace
+func (o *OvsKVImpl) shardTables() []string {
+	tables := []string{o.db_namespace + "1"}
+	for i := 2; i <= o.shards; i++ {
+		tables = append(tables, o.db_namespace+strconv.Itoa(i))
+	}
+	return tables
+}
+
+// queryTables returns shard tables which may hold keys matching condition
+// op of the key. Subtree of a top level component lives in one shard,
+// anything else has to be fanned out to all of them.
+func (o *OvsKVImpl) queryTables(op, key string) []string {
+	if op == "==" || (op == "includes" && o.shardKey == nil && len(topComponent(key)) > 0) {
+		return []string{o.shardTable(key)}
+	}
+	return o.shardTables()
+}
+
+// topComponent returns the first non empty component of the key
+func topComponent(key string) string {
+	for _, c := range strings.Split(key, SEPA) {
+		if len(c) > 0 {
+			return c
+		}
+	}
+	return ""
+}
+
+// SetShards sets number of Zone_N tables keys are distributed across.
+// It has to be the same for all users of the namespace and set before
+// any other call, keys stored with a different number of shards are not
+// found.
+func (o *OvsKVImpl) SetShards(n int) error {
+	if n < 1 {
+		n = 1
 	}
-	if fail {
-		return fmt.Errorf(errStr)
+	if err := o.checkSchema(n, o.columns); err != nil {
+		return err
 	}
+	o.shards = n
 	return nil
 }
 
-// XXX: this can be improved with hash function to enable uniform distribution
-//      of keys across more then one table of the same type.
-func (o *OvsKVImpl) shardTable() string {
-	return o.db_namespace + "1"
+// SetAllowMissing makes Load leave fields which have no key as they are,
+// e.g. at zero value or default set before Load, instead of reporting
+// them in LoadError. Useful when fields are added to stored structures.
+func (o *OvsKVImpl) SetAllowMissing(allow bool) {
+	o.allowMissing = allow
+}
+
+// SetShardKeyFunc overrides what part of the key selects its shard.
+// Prefix queries are fanned out to all shards then.
+func (o *OvsKVImpl) SetShardKeyFunc(f func(key string) string) {
+	o.shardKey = f
 }
 
 // format OVSDB path Set such so that it can be filtered back in
 // ordered non-overlapping ways, like a regular condition request /a/b/c
 func pathFmt(key string) (*libovsdb.OvsSet, error) {
+        return libovsdb.NewOvsSet(pathParts(key))
+}
+
+// pathParts returns index-prefixed components of the key as used in path Set
+func pathParts(key string) []string {
 	parts := strings.Split(key, SEPA)
 	for i, _ := range parts {
 		parts[i] = strconv.Itoa(i) + OVSSET_SEPA + parts[i]
 	}
-        return libovsdb.NewOvsSet(parts)
+	return parts
+}
+
+// pathMatch evaluates condition op of key against row key in the same way
+// ovsdb-server evaluates it against path Sets. Path Set elements are
+// prefixed by position, so the Sets share an element only when the keys
+// have the same component at the same position.
+func pathMatch(op, rowKey, key string) bool {
+	row := strings.Split(rowKey, SEPA)
+	parts := strings.Split(key, SEPA)
+	found := 0
+	for i := 0; i < len(parts) && i < len(row); i++ {
+		if row[i] == parts[i] {
+			found++
+		}
+	}
+	switch op {
+	case "==":
+		return found == len(parts) && len(row) == len(parts)
+	case "!=":
+		return found != len(parts) || len(row) != len(parts)
+	case "includes":
+		return found == len(parts)
+	case "excludes":
+		return found == 0
+	}
+	return false
 }
 
 // return key from path
@@ -115,26 +285,66 @@ func pathKey(path interface {}) string {
 	return key
 }
 
-func (o *OvsKVImpl) InsertKVM(key string, val map[string]string) (string, error) {
+// revisionMutate bumps revision of rows matching condition, it goes along
+// with every update of the row
+func revisionMutate(table string, condition []interface{}) libovsdb.Operation {
+	return libovsdb.Operation{
+		Op:        OP_MUTATE,
+		Table:     table,
+		Where:     []interface{}{condition},
+		Mutations: []interface{}{libovsdb.NewMutation("revision", "+=", 1)},
+	}
+}
+
+// rowRevision returns revision of the row, JSON numbers decode as float64
+func rowRevision(r libovsdb.ResultRow) uint32 {
+	switch v := r["revision"].(type) {
+	case float64:
+		return uint32(v)
+	case int:
+		return uint32(v)
+	}
+	return 0
+}
+
+// newKVRow builds the row to be stored for the key
+func newKVRow(key string, val map[string]string) (OvsKVRow, error) {
 	var err error
 
 	kvRow := make(OvsKVRow)
 	kvRow["path"], err = pathFmt(key)
 	if err != nil {
-		return "", fmt.Errorf("path error: %v\n", err)
+		return nil, fmt.Errorf("path error: %v\n", err)
 	}
 	kvRow["data"], err = libovsdb.NewOvsMap(val)
 	if err != nil {
-		return "", fmt.Errorf("data error: %v\n", err)
+		return nil, fmt.Errorf("data error: %v\n", err)
+	}
+	// plain write detaches the key from TTL and lease
+	kvRow["expires"] = 0
+	kvRow["lease"] = ""
+	return kvRow, nil
+}
+
+func (o *OvsKVImpl) InsertKVM(key string, val map[string]string) (string, error) {
+	return o.InsertKVMCtx(context.Background(), key, val)
+}
+
+// InsertKVMCtx works as InsertKVM, giving up when ctx is done
+func (o *OvsKVImpl) InsertKVMCtx(ctx context.Context, key string, val map[string]string) (string, error) {
+	kvRow, err := newKVRow(key, val)
+	if err != nil {
+		return "", err
 	}
+	kvRow["revision"] = 1
 
 	insertOp := libovsdb.Operation{
 		Op:       OP_INSERT,
-		Table:    o.shardTable(),
+		Table:    o.shardTable(key),
 		Row:      kvRow,
 	}
-	reply, err := o.ovs.Transact(o.db_name, insertOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{insertOp})
+	reply, err := o.transact(ctx, insertOp)
+	err = isTransactError(reply, err, []libovsdb.Operation{insertOp}, key)
 	if err != nil {
 		return "", err
 	}
@@ -145,19 +355,27 @@ func (o *OvsKVImpl) InsertKV(key, val string) (string, error) {
 	return o.InsertKVM(key, o.V(val))
 }
 
+// InsertKVCtx works as InsertKV, giving up when ctx is done
+func (o *OvsKVImpl) InsertKVCtx(ctx context.Context, key, val string) (string, error) {
+	return o.InsertKVMCtx(ctx, key, o.V(val))
+}
+
 func (o *OvsKVImpl) SetKVM(key string, val map[string]string) (string, error) {
-	var err error
+	return o.SetKVMCtx(context.Background(), key, val)
+}
 
-	kvRow := make(OvsKVRow)
-	kvRow["path"], err = pathFmt(key)
-	if err != nil {
-		return "", fmt.Errorf("path error: %v\n", err)
-	}
-	kvRow["data"], err = libovsdb.NewOvsMap(val)
+// SetKVMCtx works as SetKVM, giving up when ctx is done
+func (o *OvsKVImpl) SetKVMCtx(ctx context.Context, key string, val map[string]string) (string, error) {
+	kvRow, err := newKVRow(key, val)
 	if err != nil {
-		return "", fmt.Errorf("data error: %v\n", err)
+		return "", err
 	}
+	return o.setKVRow(ctx, key, kvRow)
+}
 
+// setKVRow updates row of the key or inserts it if it does not exist.
+// Guard operations go first in both transactions.
+func (o *OvsKVImpl) setKVRow(ctx context.Context, key string, kvRow OvsKVRow, guard ...libovsdb.Operation) (string, error) {
 	// update if exists
 	pathSet, err := pathFmt(key)
 	if err != nil {
@@ -166,102 +384,149 @@ func (o *OvsKVImpl) SetKVM(key string, val map[string]string) (string, error) {
         condition := libovsdb.NewCondition("path", "==", pathSet)
 	updateOp := libovsdb.Operation{
 		Op:       OP_UPDATE,
-		Table:    o.shardTable(),
+		Table:    o.shardTable(key),
                 Where:    []interface{}{condition},
 		Row:      kvRow,
 	}
-	reply, err := o.ovs.Transact(o.db_name, updateOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{updateOp})
+	mutateOp := revisionMutate(o.shardTable(key), condition)
+	ops := append(append([]libovsdb.Operation{}, guard...), updateOp, mutateOp)
+	keys := make([]string, len(ops))
+	for i := range keys {
+		keys[i] = key
+	}
+	reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
 	if err != nil {
 		return "", err
 	}
-	if reply[0].Count == 1 {
-		return reply[0].UUID.GoUUID, nil
-	} else if reply[0].Count > 1 {
-		return "", fmt.Errorf("Unable update multi-key: %s\n", key)
+	n := len(guard)
+	if reply[n].Count == 1 {
+		return reply[n].UUID.GoUUID, nil
+	} else if reply[n].Count > 1 {
+		return "", fmt.Errorf("%w: %s", ErrMultiKey, key)
 	}
 
 	// insert new
-	insertOp := libovsdb.Operation{
-		Op:       OP_INSERT,
-		Table:    o.shardTable(),
-		Row:      kvRow,
-	}
-	reply, err = o.ovs.Transact(o.db_name, insertOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{insertOp})
+	ops = append(append([]libovsdb.Operation{}, guard...), insertOp(o.shardTable(key), kvRow))
+	reply, err = o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
 	if err != nil {
 		return "", err
 	}
-	return reply[0].UUID.GoUUID, nil
+	return reply[n].UUID.GoUUID, nil
 }
 
 func (o *OvsKVImpl) SetKV(key, val string) (string, error) {
 	return o.SetKVM(key, o.V(val))
 }
 
+// SetKVCtx works as SetKV, giving up when ctx is done
+func (o *OvsKVImpl) SetKVCtx(ctx context.Context, key, val string) (string, error) {
+	return o.SetKVMCtx(ctx, key, o.V(val))
+}
+
 func (o *OvsKVImpl) DeleteKV(op, key string) (int, error) {
+	return o.DeleteKVCtx(context.Background(), op, key)
+}
+
+// DeleteKVCtx works as DeleteKV, giving up when ctx is done
+func (o *OvsKVImpl) DeleteKVCtx(ctx context.Context, op, key string) (int, error) {
 	pathSet, err := pathFmt(key)
 	if err != nil {
 		return 0, fmt.Errorf("path error: %v\n", err)
 	}
         condition := libovsdb.NewCondition("path", op, pathSet)
-        deleteOp := libovsdb.Operation{
-                Op:    OP_DELETE,
-		Table:    o.shardTable(),
-                Where: []interface{}{condition},
-        }
-        reply, err := o.ovs.Transact(o.db_name, deleteOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{deleteOp})
+	var ops []libovsdb.Operation
+	var keys []string
+	for _, table := range o.queryTables(op, key) {
+		ops = append(ops, libovsdb.Operation{
+			Op:    OP_DELETE,
+			Table: table,
+			Where: []interface{}{condition},
+		})
+		keys = append(keys, key)
+	}
+        reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
 	if err != nil {
 		return 0, err
 	}
-	return reply[0].Count, nil
+	count := 0
+	for i := range ops {
+		count += reply[i].Count
+	}
+	return count, nil
 }
 
 func (o *OvsKVImpl) GetKV(op, key string) (OvsKVRows, error) {
-	var condition []interface{}
-	pathSet, err := pathFmt(key)
-	if err != nil {
-		return nil, err
-	}
-	condition = libovsdb.NewCondition("path", op, pathSet)
-        selectOp := libovsdb.Operation{
-                Op:      OP_SELECT,
-		Table:    o.shardTable(),
-                Where:   []interface{}{condition},
-                Columns: []string{"_uuid","path","data"},
-        }
-        reply, err := o.ovs.Transact(o.db_name, selectOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{selectOp})
+	return o.GetKVCtx(context.Background(), op, key)
+}
+
+// GetKVCtx works as GetKV, giving up when ctx is done
+func (o *OvsKVImpl) GetKVCtx(ctx context.Context, op, key string) (OvsKVRows, error) {
+	rows, err := o.GetKVMCtx(ctx, op, key)
 	if err != nil {
 		return nil, err
 	}
-	res := make(OvsKVRows, len(reply[0].Rows))
-	for i, r := range reply[0].Rows {
+	res := make(OvsKVRows, len(*rows))
+	for i, r := range *rows {
 		row := make(OvsKVMap)
 		row["key"] = pathKey(r["path"])
 		row["value"] = fmt.Sprintf("%v", r["data"].(libovsdb.OvsMap).GoMap["v"])
 		row["uuid"] = r["_uuid"].(libovsdb.UUID).GoUUID
+		row["revision"] = strconv.FormatUint(uint64(rowRevision(r)), 10)
 		res[i] = row
         }
 	return res, nil
 }
 
+// GetKVM returns rows matching op of the key, merged from all shards
+// which may hold them
 func (o *OvsKVImpl) GetKVM(op, key string) (*[]libovsdb.ResultRow, error) {
+	return o.GetKVMCtx(context.Background(), op, key)
+}
+
+// GetKVMCtx works as GetKVM, giving up when ctx is done
+func (o *OvsKVImpl) GetKVMCtx(ctx context.Context, op, key string) (*[]libovsdb.ResultRow, error) {
+	columns := append([]string{"_uuid", "path", "data", "revision"}, o.extraColumns()...)
+	rows, err := o.selectRows(ctx, op, key, columns...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows, nil
+}
+
+// selectRows returns columns of rows matching op of the key in all tables
+// the key may be in. Rows served by the cache carry all columns.
+func (o *OvsKVImpl) selectRows(ctx context.Context, op, key string, columns ...string) ([]libovsdb.ResultRow, error) {
+	if rows, ok := o.cached(ctx, op, key); ok {
+		return rows, nil
+	}
+
 	pathSet, err := pathFmt(key)
+	if err != nil {
+		return nil, err
+	}
         condition := libovsdb.NewCondition("path", op, pathSet)
-        selectOp := libovsdb.Operation{
-                Op:      OP_SELECT,
-		Table:    o.shardTable(),
-                Where:   []interface{}{condition},
-                Columns: []string{"_uuid","path","data"},
-        }
-        reply, err := o.ovs.Transact(o.db_name, selectOp)
-	err = isTransactError(reply, err, []libovsdb.Operation{selectOp})
+	var ops []libovsdb.Operation
+	for _, table := range o.queryTables(op, key) {
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   table,
+			Where:   []interface{}{condition},
+			Columns: columns,
+		})
+	}
+        reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops)
 	if err != nil {
 		return nil, err
 	}
-	return &reply[0].Rows, nil
+	rows := reply[0].Rows
+	for i := 1; i < len(ops); i++ {
+		rows = append(rows, reply[i].Rows...)
+	}
+	return rows, nil
 }
 
 // checkDir will check whether the component is a directory under parent node.
@@ -308,13 +573,22 @@ func walk(root *node, nodePath string, walkFunc func(prev *node, component strin
 }
 
 func (o *OvsKVImpl) GetKVNodes(op, key string) (*node, error) {
-	rows, err := o.GetKVM(op, key)
+	return o.GetKVNodesCtx(context.Background(), op, key)
+}
+
+// GetKVNodesCtx works as GetKVNodes, giving up when ctx is done
+func (o *OvsKVImpl) GetKVNodesCtx(ctx context.Context, op, key string) (*node, error) {
+	rows, err := o.GetKVMCtx(ctx, op, key)
 	if err != nil {
 		return nil, err
 	}
+	return nodeTree(*rows)
+}
 
+// nodeTree builds the node tree of rows, rows are referenced by the nodes
+func nodeTree(rows []libovsdb.ResultRow) (*node, error) {
 	root := newDir("/", 0, nil)
-	for i, r := range *rows {
+	for i, r := range rows {
 		nodePath := pathKey(r["path"])
 
 		dirName, nodeName := path.Split(nodePath)
@@ -330,7 +604,7 @@ func (o *OvsKVImpl) GetKVNodes(op, key string) (*node, error) {
 			continue
 		}
 
-		n = newKV(nodePath, &(*rows)[i], 0, d)
+		n = newKV(nodePath, &rows[i], 0, d)
 
 		// we are sure d is a directory and does not have the children with name n.Name
 		if err := d.Add(n); err != nil {
@@ -341,7 +615,15 @@ func (o *OvsKVImpl) GetKVNodes(op, key string) (*node, error) {
 }
 
 func (o *OvsKVImpl) Disconnect() {
-        o.ovs.Disconnect()
+	o.mu.Lock()
+	o.closed = true
+	watch := o.watch
+	o.mu.Unlock()
+
+	if watch != nil {
+		watch.close()
+	}
+        o.client().Disconnect()
 }
 
 func (o *OvsKVImpl) V(v string) map[string]string {
@@ -351,124 +633,170 @@ func (o *OvsKVImpl) V(v string) map[string]string {
 // Save stores a structure in ovskv.
 // Only attributes with the tag 'ovskv' are going to be saved.
 func (o *OvsKVImpl) Save() error {
-	return o.saveField(o.data, "")
+	return o.SaveCtx(context.Background())
+}
+
+// SaveCtx works as Save, it stops at the first row which could not be
+// stored in time, rows stored before it are not rolled back
+func (o *OvsKVImpl) SaveCtx(ctx context.Context) error {
+	return o.saveField(ctx, o, o.data, "")
 }
 
 // SaveField saves a specific field from the configuration structure.
 // Works in the same way of Save, but it can be used to save specific parts of the configuration,
 // avoiding excessive requests to ovsdb cluster
 func (o *OvsKVImpl) SaveField(field interface{}) error {
+	return o.SaveFieldCtx(context.Background(), field)
+}
+
+// SaveFieldCtx works as SaveField, giving up when ctx is done
+func (o *OvsKVImpl) SaveFieldCtx(ctx context.Context, field interface{}) error {
 	path, _, err := o.getInfo(field)
 	if err != nil {
 		return err
 	}
 
-	return o.saveField(reflect.ValueOf(field), path)
+	return o.saveField(ctx, o, reflect.ValueOf(field), path)
+}
+
+// kvWriter stores rows produced by saveField, either right away or as
+// part of a Txn
+type kvWriter interface {
+	setKVM(ctx context.Context, key string, val map[string]string) error
+	// deleteKV removes the key and keys under it
+	deleteKV(ctx context.Context, key string) error
+}
+
+func (o *OvsKVImpl) setKVM(ctx context.Context, key string, val map[string]string) error {
+	_, err := o.SetKVMCtx(ctx, key, val)
+	return err
+}
+
+func (o *OvsKVImpl) deleteKV(ctx context.Context, key string) error {
+	_, err := o.DeleteKVCtx(ctx, "includes", key)
+	return err
 }
 
-func (o *OvsKVImpl) saveField(field reflect.Value, prefix string) error {
-	if field.Kind() == reflect.Ptr {
+func (o *OvsKVImpl) saveField(ctx context.Context, w kvWriter, field reflect.Value, prefix string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			// nil is stored as absent key, the whole structure is
+			// not deleted if it is nil
+			if len(prefix) == 0 {
+				return nil
+			}
+			return w.deleteKV(ctx, prefix)
+		}
 		field = field.Elem()
 	}
 
-	switch field.Kind() {
+	switch {
 
-	case reflect.Struct:
-		for i := 0; i < field.NumField(); i++ {
-			subfield := field.Field(i)
-			subfieldType := field.Type().Field(i)
+	case implements(field.Type(), marshalerType):
+		m, err := as(field, marshalerType).(OvsKVMarshaler).MarshalOvsKV()
+		if err != nil {
+			return fmt.Errorf("%s: %v\n", prefix, err)
+		}
+		if err := w.setKVM(ctx, prefix, m); err != nil {
+			return err
+		}
 
-			path := normalizeTag(subfieldType.Tag.Get(OVSKV_TAG))
-			if len(path) == 0 {
+	case isValue(field.Type()):
+		value, err := formatValue(field)
+		if err != nil {
+			return fmt.Errorf("%s: %v\n", prefix, err)
+		}
+		if err := w.setKVM(ctx, prefix, o.V(value)); err != nil {
+			return err
+		}
+
+	case field.Kind() == reflect.Struct:
+		fields, err := structFields(field)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if f.tag.readOnly {
 				continue
 			}
-			path = prefix + "/" + path
+			path := prefix + "/" + f.tag.name
 
-			if err := o.saveField(subfield, path); err != nil {
+			if f.tag.omitEmpty && f.value.IsZero() {
+				if err := w.deleteKV(ctx, path); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := o.saveField(ctx, w, f.value, path); err != nil {
 				return err
 			}
 		}
 
-	case reflect.Map:
+	case (field.Kind() == reflect.Map || field.Kind() == reflect.Slice) && field.Len() == 0:
+		// data column holds at least one pair, so empty is stored
+		// as absent as well
+		if err := w.deleteKV(ctx, prefix); err != nil {
+			return err
+		}
+
+	case field.Kind() == reflect.Map:
 		for _, key := range field.MapKeys() {
 			value := field.MapIndex(key)
 
-			if value.Kind() == reflect.Struct {
+			if !isValue(value.Type()) {
 				path := prefix + "/" + key.String()
-				if err := o.saveField(value, path); err != nil {
+				if err := o.saveField(ctx, w, value, path); err != nil {
 					return err
 				}
 			} else {
 				m := make(map[string]string)
 				for _, key := range field.MapKeys() {
-					value := field.MapIndex(key)
-					m[key.String()] = fmt.Sprintf("%v", value)
+					value, err := formatValue(field.MapIndex(key))
+					if err != nil {
+						return fmt.Errorf("%s/%s: %v\n", prefix, key.String(), err)
+					}
+					m[key.String()] = value
 				}
-				if _, err := o.SetKVM(prefix, m); err != nil {
+				if err := w.setKVM(ctx, prefix, m); err != nil {
 					return err
 				}
 				break
 			}
 		}
 
-	case reflect.Slice:
+	case field.Kind() == reflect.Slice:
 		for i := 0; i < field.Len(); i++ {
 			item := field.Index(i)
 
-			if item.Kind() == reflect.Struct {
+			if !isValue(item.Type()) {
 				path := fmt.Sprintf("%s/%d", prefix, i)
 
-				if err := o.saveField(item, path); err != nil {
+				if err := o.saveField(ctx, w, item, path); err != nil {
 					return err
 				}
 			} else {
 				m := make(map[string]string)
 				for i := 0; i < field.Len(); i++ {
-					item := field.Index(i)
-					m[strconv.Itoa(i)] = fmt.Sprintf("%v", item)
+					value, err := formatValue(field.Index(i))
+					if err != nil {
+						return fmt.Errorf("%s/%d: %v\n", prefix, i, err)
+					}
+					m[strconv.Itoa(i)] = value
 				}
-				if _, err := o.SetKVM(prefix, m); err != nil {
+				if err := w.setKVM(ctx, prefix, m); err != nil {
 					return err
 				}
 				break
 			}
 		}
-
-	case reflect.String:
-		value := field.Interface().(string)
-		if _, err := o.SetKV(prefix, value); err != nil {
-			return err
-		}
-
-	case reflect.Int:
-		value := field.Interface().(int)
-		if _, err := o.SetKV(prefix, strconv.FormatInt(int64(value), 10)); err != nil {
-			return err
-		}
-
-	case reflect.Int64:
-		value := field.Interface().(int64)
-		if _, err := o.SetKV(prefix, strconv.FormatInt(value, 10)); err != nil {
-			return err
-		}
-
-	case reflect.Bool:
-		value := field.Interface().(bool)
-
-		var valueStr string
-		if value {
-			valueStr = "true"
-		} else {
-			valueStr = "false"
-		}
-
-		if _, err := o.SetKV(prefix, valueStr); err != nil {
-			return err
-		}
 	}
 
 	o.info[prefix] = info{
-		field: field,
+		field:   field,
+		version: o.info[prefix].version,
 	}
 
 	return nil
@@ -478,20 +806,23 @@ func (o *OvsKVImpl) preload(field reflect.Value, prefix string) {
 	field = field.Elem()
 
 	switch field.Kind() {
+	case reflect.Ptr:
+		// pointed to value is mapped, so that SaveField can be given
+		// the pointer
+		if !field.IsNil() {
+			o.preload(field, prefix)
+			return
+		}
 	case reflect.Struct:
-		for i := 0; i < field.NumField(); i++ {
-			subfield := field.Field(i)
-			subfieldType := field.Type().Field(i)
-
-			path := normalizeTag(subfieldType.Tag.Get(OVSKV_TAG))
-			if len(path) == 0 {
-				continue
-			}
-			path = prefix + "/" + path
-
-			o.preload(subfield.Addr(), path)
+		// bad tags are reported by Save and Load
+		fields, _ := structFields(field)
+		for _, f := range fields {
+			o.preload(f.value.Addr(), prefix+"/"+f.tag.name)
 		}
 	case reflect.Slice:
+		if isValue(field.Type()) {
+			break
+		}
 		for i := 0; i < field.Len(); i++ {
 			subfield := field.Index(i)
 			path := prefix + "/" + strconv.Itoa(i)
@@ -540,12 +871,22 @@ func (o *OvsKVImpl) getInfo(field interface{}) (path string, info info, err erro
 // Load retrieves the data from the ovsdb into the given structure.
 // Only attributes with the tag 'ovskv' will be filled.
 func (o *OvsKVImpl) Load() error {
-	return o.load(o.data, "")
+	return o.LoadCtx(context.Background())
+}
+
+// LoadCtx works as Load, giving up when ctx is done
+func (o *OvsKVImpl) LoadCtx(ctx context.Context) error {
+	return o.load(ctx, o.data, "")
 }
 
 // LoadField retrieves the specific data from the ovsdb into the given structure.
 // Only attributes with the tag 'ovskv' will be filled.
 func (o *OvsKVImpl) LoadField(data interface{}, prefix string) error {
+	return o.LoadFieldCtx(context.Background(), data, prefix)
+}
+
+// LoadFieldCtx works as LoadField, giving up when ctx is done
+func (o *OvsKVImpl) LoadFieldCtx(ctx context.Context, data interface{}, prefix string) error {
 	if data != nil {
 		dataValue := reflect.ValueOf(data)
 
@@ -553,9 +894,9 @@ func (o *OvsKVImpl) LoadField(data interface{}, prefix string) error {
 			return fmt.Errorf("Error: invalid data kind: %+v\n", dataValue.Kind())
 		}
 		o.preload(dataValue, prefix)
-		return o.load(dataValue, prefix)
+		return o.load(ctx, dataValue, prefix)
 	}
-	return o.load(o.data, prefix)
+	return o.load(ctx, o.data, prefix)
 }
 
 func traverseFind(node *node, searchPath string) *node {
@@ -576,141 +917,168 @@ func traverseFind(node *node, searchPath string) *node {
 	return nil
 }
 
-func (o *OvsKVImpl) load(data reflect.Value, prefix string) error {
+func (o *OvsKVImpl) load(ctx context.Context, data reflect.Value, prefix string) error {
 	if data.Kind() != reflect.Ptr {
 		return fmt.Errorf("Error: invalid data, expecting ptr\n")
 	}
 
 	// load all nodes as one op
-	nodes, err := o.GetKVNodes("includes", prefix)
+	nodes, err := o.GetKVNodesCtx(ctx, "includes", prefix)
 	if err != nil {
 		return err
 	}
 
-	data = data.Elem()
-	for i := 0; i < data.NumField(); i++ {
-		field := data.Field(i)
-		fieldType := data.Type().Field(i)
-
-		fieldName := normalizeTag(fieldType.Tag.Get(OVSKV_TAG))
-		if len(fieldName) == 0 {
-			continue
-		}
-		path := prefix + "/" + fieldName
+	lerr := &LoadError{}
+	fields, err := structFields(data.Elem())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		path := prefix + "/" + f.tag.name
 
 		node := traverseFind(nodes, path)
 		if node == nil {
-			panic(fmt.Errorf("expected path %s not found", path))
+			f.absent(path, lerr)
+			continue
 		}
 
-		if err := o.fillField(field, node, path, fieldName); err != nil {
+		if err := o.fillField(ctx, f.value, node, path, f.tag.name, lerr); err != nil {
 			return err
 		}
 	}
 
+	if o.allowMissing {
+		lerr.Missing = nil
+	}
+	if len(lerr.Missing) > 0 || len(lerr.Invalid) > 0 {
+		return lerr
+	}
 	return nil
 }
 
-func getPathIdx(path string) int {
+// optional returns whether absent key of the field means nil rather than
+// a missing key
+func optional(field reflect.Value) bool {
+	switch field.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		return true
+	}
+	return false
+}
+
+func getPathIdx(path string) (int, error) {
 	parts := strings.Split(path, SEPA)
-	idx, _ := strconv.Atoi(parts[len(parts)-1])
-	return idx
+	return strconv.Atoi(parts[len(parts)-1])
 }
 
-func (o *OvsKVImpl) fillField(field reflect.Value, node *node, prefix, fieldName string) error {
-	switch field.Kind() {
-	case reflect.Struct:
-		for i := 0; i < field.NumField(); i++ {
-			subfield := field.Field(i)
-			subfieldType := field.Type().Field(i)
+// fillField sets field from node and its children. Paths which could not
+// be filled are collected in lerr, only ctx and tag errors are returned.
+func (o *OvsKVImpl) fillField(ctx context.Context, field reflect.Value, node *node, prefix, fieldName string, lerr *LoadError) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-			fieldName := normalizeTag(subfieldType.Tag.Get(OVSKV_TAG))
-			if len(fieldName) == 0 {
-				continue
-			}
-			path := prefix + "/" + fieldName
+	switch {
+	case field.Kind() != reflect.Ptr && implements(field.Type(), unmarshalerType):
+		m, ok := node.stringMap()
+		if !ok {
+			lerr.invalid(prefix, fmt.Errorf("expected value, found directory"))
+			break
+		}
+		if err := as(field, unmarshalerType).(OvsKVUnmarshaler).UnmarshalOvsKV(m); err != nil {
+			lerr.invalid(prefix, err)
+		}
 
+	case isValue(field.Type()):
+		if node.IsDir() {
+			lerr.invalid(prefix, fmt.Errorf("expected value, found directory"))
+			break
+		}
+		if field.Kind() == reflect.String && fieldName == OVSKV_UUID {
+			field.SetString(node.UUID())
+			break
+		}
+		s, ok := node.value()
+		if !ok {
+			lerr.invalid(prefix, fmt.Errorf("no value"))
+			break
+		}
+		value, err := parseValue(field.Type(), s)
+		if err != nil {
+			lerr.invalid(prefix, err)
+			break
+		}
+		field.Set(value)
+
+	case field.Kind() == reflect.Ptr:
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return o.fillField(ctx, field.Elem(), node, prefix, fieldName, lerr)
+
+	case field.Kind() == reflect.Struct:
+		fields, err := structFields(field)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			path := prefix + "/" + f.tag.name
+
+			found := false
 			for _, child := range node.Children {
 				if path == child.Key() {
-					if err := o.fillField(subfield, child, path, fieldName); err != nil {
+					if err := o.fillField(ctx, f.value, child, path, f.tag.name, lerr); err != nil {
 						return err
 					}
+					found = true
 					break
 				}
 			}
+			if !found {
+				f.absent(path, lerr)
+			}
 		}
 
-	case reflect.Map:
+	case field.Kind() == reflect.Map:
+		if field.Type().Key().Kind() != reflect.String {
+			lerr.invalid(prefix, fmt.Errorf("not supported map key kind: %v", field.Type().Key().Kind()))
+			break
+		}
 		field.Set(reflect.MakeMap(field.Type()))
 
-		switch field.Type().Elem().Kind() {
-		case reflect.Struct:
+		if !isValue(field.Type().Elem()) {
 			for _, node := range node.Children {
 				newStruct := reflect.New(field.Type().Elem()).Elem()
-				if err := o.fillField(newStruct, node, node.Key(), fieldName); err != nil {
+				if err := o.fillField(ctx, newStruct, node, node.Key(), fieldName, lerr); err != nil {
 					return err
 				}
 
 				pathParts := strings.Split(node.Key(), "/")
 
 				field.SetMapIndex(
-					reflect.ValueOf(pathParts[len(pathParts)-1]),
+					reflect.ValueOf(pathParts[len(pathParts)-1]).Convert(field.Type().Key()),
 					newStruct,
 				)
 			}
+			break
+		}
 
-		case reflect.String:
-			m := node.Map()
-			for k, v := range m {
-				field.SetMapIndex(
-					reflect.ValueOf(k.(string)),
-					reflect.ValueOf(v.(string)),
-				)
-			}
-
-		case reflect.Int:
-			m := node.Map()
-			for k, v := range m {
-				val, _ := strconv.Atoi(v.(string))
-				field.SetMapIndex(
-					reflect.ValueOf(k.(string)),
-					reflect.ValueOf(val),
-				)
-			}
-
-		case reflect.Int64:
-			m := node.Map()
-			for k, v := range m {
-				val, err := strconv.ParseInt(v.(string), 10, 64)
-				if err != nil {
-					return err
-				}
-				field.SetMapIndex(
-					reflect.ValueOf(k.(string)),
-					reflect.ValueOf(val),
-				)
-			}
-
-		case reflect.Bool:
-			m := node.Map()
-			for k, v := range m {
-				var val bool
-				if v.(string) == "true" {
-					val = true
-				} else if v.(string) == "false" {
-					val = false
-				}
-				field.SetMapIndex(
-					reflect.ValueOf(k.(string)),
-					reflect.ValueOf(val),
-				)
+		m, ok := node.stringMap()
+		if !ok {
+			lerr.invalid(prefix, fmt.Errorf("expected value, found directory"))
+			break
+		}
+		for k, v := range m {
+			value, err := parseValue(field.Type().Elem(), v)
+			if err != nil {
+				lerr.invalid(prefix+"/"+k, err)
+				continue
 			}
+			field.SetMapIndex(reflect.ValueOf(k).Convert(field.Type().Key()), value)
 		}
 
-	case reflect.Slice:
-		switch field.Type().Elem().Kind() {
-
-		case reflect.Struct:
+	case field.Kind() == reflect.Slice:
+		if !isValue(field.Type().Elem()) {
 			childrenLen := len(node.Children)
 			newSlice := reflect.MakeSlice(field.Type(), childrenLen, childrenLen)
 			field.Set(newSlice)
@@ -718,110 +1086,45 @@ func (o *OvsKVImpl) fillField(field reflect.Value, node *node, prefix, fieldName
 			for _, item := range node.Children {
 				// children nodes out of order, so, we need to extract
 				// order from the currently processing path
-				idx := getPathIdx(item.Path)
-				newStruct := reflect.New(field.Type().Elem()).Elem()
-
-			SubitemLoop:
-				for _, subitem := range item.Children {
-					for j := 0; j < newStruct.NumField(); j++ {
-						subfield := newStruct.Field(j)
-						subfieldType := newStruct.Type().Field(j)
-
-						fieldName := normalizeTag(subfieldType.Tag.Get(OVSKV_TAG))
-						if len(fieldName) == 0 {
-							continue
-						}
-						path := fmt.Sprintf("%s/%d/%s", prefix, idx, fieldName)
-
-						if path == subitem.Key() {
-							if err := o.fillField(subfield, subitem, path, fieldName); err != nil {
-								return err
-							}
-							continue SubitemLoop
-						}
-					}
-				}
-				newSlice.Index(idx).Set(newStruct)
-			}
-
-
-		case reflect.String:
-			m := node.Map()
-			newSlice := reflect.MakeSlice(field.Type(), len(m), len(m))
-			field.Set(newSlice)
-			for k, v := range m {
-				idx, _ := strconv.Atoi(k.(string))
-				newSlice.Index(idx).Set(reflect.ValueOf(v.(string)))
-			}
-
-		case reflect.Int:
-			m := node.Map()
-			newSlice := reflect.MakeSlice(field.Type(), len(m), len(m))
-			field.Set(newSlice)
-			for k, v := range m {
-				idx, _ := strconv.Atoi(k.(string))
-				value, err := strconv.Atoi(v.(string))
-				if err != nil {
-					return err
+				idx, err := getPathIdx(item.Path)
+				if err != nil || idx < 0 || idx >= childrenLen {
+					lerr.invalid(item.Path, fmt.Errorf("invalid slice index"))
+					continue
 				}
-				newSlice.Index(idx).Set(reflect.ValueOf(value))
-			}
-
-		case reflect.Int64:
-			m := node.Map()
-			newSlice := reflect.MakeSlice(field.Type(), len(m), len(m))
-			field.Set(newSlice)
-			for k, v := range m {
-				idx, _ := strconv.Atoi(k.(string))
-				value, err := strconv.ParseInt(v.(string), 10, 64)
-				if err != nil {
+				path := fmt.Sprintf("%s/%d", prefix, idx)
+				if err := o.fillField(ctx, newSlice.Index(idx), item, path, fieldName, lerr); err != nil {
 					return err
 				}
-				newSlice.Index(idx).Set(reflect.ValueOf(value))
-			}
-
-		case reflect.Bool:
-			m := node.Map()
-			newSlice := reflect.MakeSlice(field.Type(), len(m), len(m))
-			field.Set(newSlice)
-			for k, v := range m {
-				idx, _ := strconv.Atoi(k.(string))
-				if v.(string) == "true" {
-					newSlice.Index(idx).Set(reflect.ValueOf(true))
-				} else if v.(string) == "false" {
-					newSlice.Index(idx).Set(reflect.ValueOf(false))
-				}
 			}
+			break
 		}
 
-	case reflect.String:
-		if fieldName == OVSKV_UUID {
-			field.SetString(node.UUID())
-		} else {
-			field.SetString(node.Value())
-		}
-
-	case reflect.Int, reflect.Int64:
-		value, err := strconv.ParseInt(node.Value(), 10, 64)
-		if err != nil {
-			return err
+		m, ok := node.stringMap()
+		if !ok {
+			lerr.invalid(prefix, fmt.Errorf("expected value, found directory"))
+			break
 		}
-
-		field.SetInt(value)
-
-	case reflect.Bool:
-		if node.Value() == "true" {
-			field.SetBool(true)
-		} else if node.Value() == "false" {
-			field.SetBool(false)
+		newSlice := reflect.MakeSlice(field.Type(), len(m), len(m))
+		field.Set(newSlice)
+		for k, v := range m {
+			idx, err := strconv.Atoi(k)
+			if err != nil || idx < 0 || idx >= len(m) {
+				lerr.invalid(prefix+"/"+k, fmt.Errorf("invalid slice index"))
+				continue
+			}
+			value, err := parseValue(field.Type().Elem(), v)
+			if err != nil {
+				lerr.invalid(prefix+"/"+k, err)
+				continue
+			}
+			newSlice.Index(idx).Set(value)
 		}
 
-	default:
-		panic(fmt.Errorf("not supported field kind: %v", field.Kind()))
 	}
 
 	o.info[node.Path] = info{
 		field:   field,
+		version: node.Revision(),
 	}
 
 	return nil
@@ -844,7 +1147,11 @@ func Init(db_name, db_connect, db_namespace string, data interface{}) (*OvsKVImp
 		db_name:      db_name,
 		db_connect:   db_connect,
 		db_namespace: db_namespace,
+		shards:       1,
+		columns:      Columns{Path: PATH_COLUMN, Data: DATA_COLUMN},
 		info:         make(map[string]info),
+		backoffMin:   RECONNECT_INTERVAL,
+		backoffMax:   RECONNECT_MAX_INTERVAL,
 	}
 
 	if data != nil {
@@ -857,7 +1164,20 @@ func Init(db_name, db_connect, db_namespace string, data interface{}) (*OvsKVImp
 		imp.preload(imp.data, "")
 	}
 
-	o, err := libovsdb.Connect(db_connect, nil)
-	imp.ovs = o
-	return imp, err
+	c, err := imp.dial()
+	if err != nil {
+		return imp, err
+	}
+	imp.install(c)
+
+	// misconfigured database fails here rather than on first Transact,
+	// unless it uses other column names which SetColumns is yet to map
+	if err := imp.checkSchema(imp.shards, imp.columns); err != nil {
+		if !imp.customColumns() {
+			imp.Disconnect()
+			return imp, err
+		}
+		imp.schemaErr = err
+	}
+	return imp, nil
 }
@@ -1,13 +1,19 @@
 package ovskv
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 	"math/rand"
+	"net"
+	"os"
 
+	"github.com/ebay/libovsdb"
 	"github.com/stretchr/testify/assert"
 
 	"."
@@ -15,10 +21,19 @@ import (
 
 const (
 	DB_NAME string = "TestKV"
-	DB_CONNECT string = "tcp:127.0.0.1:6641"
 	DB_NAMESPACE string = "Zone_"
 )
 
+// OVSKV_CONNECT="memory:", with the colon, runs tests against in-process backend
+var DB_CONNECT string = testConnect()
+
+func testConnect() string {
+	if connect := os.Getenv("OVSKV_CONNECT"); connect != "" {
+		return connect
+	}
+	return "tcp:127.0.0.1:6641"
+}
+
 func TestKV(t *testing.T) {
 	fmt.Println("Verify *KV and *KVM interfaces")
 	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
@@ -50,45 +65,979 @@ func TestKV(t *testing.T) {
 	assert.Equal(t, err, nil)
 	_, err = ovs.SetKVM("Test1/Tenants/Foo", map[string]string{"Description":"desc3","par1":"val1"})
 	assert.Equal(t, err, nil)
-	_, err = ovs.SetKVM("Test1/Tenants/Foo/Chassis/1", map[string]string{"Description":"desc3"})
+	_, err = ovs.SetKVM("Test1/Tenants/Foo/Chassis/1", map[string]string{"Description":"desc3"})
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("Test1/Tenants/Foo/Chassis/1/NetInterfaces/1", "val1")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("Test1/Tenants/Foo/Chassis/1/NetInterfaces/2", "val2")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVM("Test2", map[string]string{"Description":"desc1"})
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVM("Test2/Tenants/Foo", map[string]string{"Description":"desc3","par1":"val1"})
+	assert.Equal(t, err, nil)
+
+	rows, err = ovs.GetKV("includes", "Test1/Tenants/Foo/Chassis/1")
+	found := false
+	for i, _ := range rows {
+		if rows[i]["key"] == "Test1/Tenants/Foo/Chassis/1/NetInterfaces/2" {
+			found = true
+			break
+		}
+	}
+	assert.Equal(t, true, found)
+
+	rows, err = ovs.GetKV("includes", "Test2")
+	found = false
+	for i, _ := range rows {
+		if rows[i]["key"] == "Test2/Tenants/Foo" {
+			found = true
+			break
+		}
+	}
+	assert.Equal(t, true, found)
+
+	rows, err = ovs.GetKV("==", "Test1/Tenants/Foo/Chassis/1/NetInterfaces/1")
+	assert.Equal(t, 1, len(rows))
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+	rows, err = ovs.GetKV("excludes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+        ovs.Disconnect()
+}
+
+func TestMemory(t *testing.T) {
+	fmt.Println("Verify in-memory backend shares database by name")
+	a, err := ovskv.NewMemory("TestMemory", DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	b, err := ovskv.NewMemory("TestMemory", DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = a.InsertKV("/m/a", "a")
+	assert.Equal(t, err, nil)
+
+	_, err = b.InsertKV("/m/a", "a")
+	assert.NotEqual(t, err, nil)
+
+	// memory backend is swapped in behind OvsKV
+	var kv ovskv.OvsKV = b
+	_, err = kv.SetKVM("/m/b", map[string]string{"k1":"v1","k2":"v2"})
+	assert.Equal(t, err, nil)
+
+	rows, err := a.GetKV("includes", "/m")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	rows, err = a.GetKV("excludes", "/m")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	count, err := b.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, count)
+
+	a.Disconnect()
+	b.Disconnect()
+}
+
+func TestErrors(t *testing.T) {
+	fmt.Println("Verify errors can be told apart with errors.Is and errors.As")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.InsertKV("/err/a", "a")
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.InsertKV("/err/a", "a")
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrKeyExists))
+	assert.Equal(t, false, errors.Is(err, ovskv.ErrConflict))
+	var terr *ovskv.TransactError
+	assert.Equal(t, true, errors.As(err, &terr))
+	assert.Equal(t, "constraint violation", terr.Code)
+	assert.Equal(t, "/err/a", terr.Key)
+	assert.Equal(t, 1, terr.Index)
+
+	err = ovs.Begin().Set("/err/b", "b").Insert("/err/a", "a").Commit()
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrKeyExists))
+	assert.Equal(t, true, errors.As(err, &terr))
+	assert.Equal(t, "/err/a", terr.Key)
+
+	_, err = ovs.SetKVIfVersion("/err/a", "b", 7)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+	assert.Equal(t, true, errors.As(err, &terr))
+	assert.Equal(t, ovskv.OP_WAIT, terr.Op)
+	assert.Equal(t, 0, terr.Index)
+
+	_, err = ovs.DeleteKV("includes", "/err")
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestReconnect(t *testing.T) {
+	fmt.Println("Verify connection is re-established after server restart")
+	ovs, err := ovskv.NewMemory("TestReconnect", DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	ovs.SetReconnectBackoff(10*time.Millisecond, 100*time.Millisecond)
+	assert.Equal(t, true, ovs.Healthy())
+	assert.Equal(t, nil, ovs.Ping())
+
+	disconnected := make(chan bool, 1)
+	reconnected := make(chan bool, 1)
+	ovs.SetDisconnectHook(func() { disconnected <- true })
+	ovs.SetReconnectHook(func() { reconnected <- true })
+
+	w, err := ovs.Watch("/r")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/r/a", "a")
+	assert.Equal(t, err, nil)
+	ev := <-w.Events()
+	assert.Equal(t, ovskv.WatchPut, ev.Type)
+
+	ovskv.MemoryRestart("TestReconnect")
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no disconnect")
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect")
+	}
+	assert.Equal(t, true, ovs.Healthy())
+	assert.Equal(t, nil, ovs.Ping())
+
+	ev = <-w.Events()
+	assert.Equal(t, ovskv.WatchResync, ev.Type)
+
+	_, err = ovs.SetKV("/r/b", "b")
+	assert.Equal(t, err, nil)
+	ev = <-w.Events()
+	assert.Equal(t, "/r/b", ev.Key)
+
+	rows, err := ovs.GetKV("includes", "/r")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	w.Close()
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+	ovs.Disconnect()
+	assert.Equal(t, false, ovs.Healthy())
+}
+
+func TestTxn(t *testing.T) {
+	fmt.Println("Verify Txn commits all operations or none")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKV("/txn/a", "a")
+	assert.Equal(t, err, nil)
+
+	err = ovs.Begin().Set("/txn/a", "a1").Set("/txn/b", "b").Insert("/txn/c", "c").Commit()
+	assert.Equal(t, err, nil)
+
+	rows, err := ovs.GetKV("includes", "/txn")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 3, len(rows))
+
+	rows, err = ovs.GetKV("==", "/txn/a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "a1", rows[0]["value"])
+
+	// duplicate insert aborts the whole transaction
+	err = ovs.Begin().Set("/txn/d", "d").Insert("/txn/c", "c").Commit()
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, true, strings.Contains(err.Error(), "/txn/c"))
+
+	rows, err = ovs.GetKV("==", "/txn/d")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	// set after delete of the same subtree inserts again
+	err = ovs.Begin().Delete("includes", "/txn").Set("/txn/a", "a2").Commit()
+	assert.Equal(t, err, nil)
+
+	rows, err = ovs.GetKV("includes", "/txn")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, "a2", rows[0]["value"])
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestWatch(t *testing.T) {
+	fmt.Println("Verify Watch delivers events of keys under prefix")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	w, err := ovs.Watch("/watch")
+	assert.Equal(t, err, nil)
+
+	next := func() ovskv.WatchEvent {
+		select {
+		case ev := <-w.Events():
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("no watch event")
+		}
+		return ovskv.WatchEvent{}
+	}
+
+	_, err = ovs.SetKV("/other", "x")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/watch/a", "a")
+	assert.Equal(t, err, nil)
+
+	ev := next()
+	assert.Equal(t, ovskv.WatchPut, ev.Type)
+	assert.Equal(t, "/watch/a", ev.Key)
+	assert.Equal(t, "a", ev.NewValue["v"])
+
+	_, err = ovs.SetKV("/watch/a", "b")
+	assert.Equal(t, err, nil)
+
+	ev = next()
+	assert.Equal(t, ovskv.WatchPut, ev.Type)
+	assert.Equal(t, "a", ev.OldValue["v"])
+	assert.Equal(t, "b", ev.NewValue["v"])
+
+	_, err = ovs.DeleteKV("==", "/watch/a")
+	assert.Equal(t, err, nil)
+
+	ev = next()
+	assert.Equal(t, ovskv.WatchDelete, ev.Type)
+	assert.Equal(t, "/watch/a", ev.Key)
+
+	w.Close()
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestCache(t *testing.T) {
+	fmt.Println("Verify reads served from cache follow writes")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	other, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	err = ovs.SetCache(true)
+	assert.Equal(t, err, nil)
+
+	// own writes are visible right away
+	_, err = ovs.SetKV("/cache/a", "a")
+	assert.Equal(t, err, nil)
+	rows, err := ovs.GetKV("==", "/cache/a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, len(rows))
+	assert.Equal(t, "a", rows[0]["value"])
+	assert.Equal(t, "1", rows[0]["revision"])
+
+	_, err = other.SetKV("/cache/b", "b")
+	assert.Equal(t, err, nil)
+	rows, err = ovs.GetKVCtx(ovskv.ServerRead(context.Background()), "includes", "/cache")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rows, err = ovs.GetKV("includes", "/cache")
+		assert.Equal(t, err, nil)
+		if len(rows) == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 2, len(rows))
+
+	_, err = ovs.DeleteKV("includes", "/cache")
+	assert.Equal(t, err, nil)
+	rows, err = ovs.GetKV("includes", "/cache")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	other.Disconnect()
+        ovs.Disconnect()
+}
+
+func TestTTL(t *testing.T) {
+	fmt.Println("Verify expired keys and leases are reaped")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	w, err := ovs.Watch("/ttl")
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKVWithTTL("/ttl/a", "a", 50*time.Millisecond)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVWithTTL("/ttl/b", "b", time.Hour)
+	assert.Equal(t, err, nil)
+	// plain write drops TTL
+	_, err = ovs.SetKVWithTTL("/ttl/c", "c", 50*time.Millisecond)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/ttl/c", "c")
+	assert.Equal(t, err, nil)
+
+	short, err := ovs.Grant(50 * time.Millisecond)
+	assert.Equal(t, err, nil)
+	long, err := ovs.Grant(time.Hour)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVWithLease("/ttl/d", "d", short.ID)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVWithLease("/ttl/e", "e", long.ID)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVWithLease("/ttl/f", "f", long.ID)
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVWithLease("/ttl/g", "g", "00000000-0000-0000-0000-000000000000")
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotFound))
+
+	time.Sleep(100 * time.Millisecond)
+	count, err := ovs.Reap()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, count)
+
+	rows, err := ovs.GetKV("includes", "/ttl")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 4, len(rows))
+
+	err = ovs.KeepAlive(short.ID)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotFound))
+	err = ovs.KeepAlive(long.ID)
+	assert.Equal(t, err, nil)
+
+	err = ovs.Revoke(long.ID)
+	assert.Equal(t, err, nil)
+	rows, err = ovs.GetKV("includes", "/ttl")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	deleted := make(map[string]bool)
+	for len(deleted) < 4 {
+		select {
+		case ev := <-w.Events():
+			if ev.Type == ovskv.WatchDelete {
+				deleted[ev.Key] = true
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no delete event")
+		}
+	}
+	assert.Equal(t, map[string]bool{"/ttl/a": true, "/ttl/d": true, "/ttl/e": true, "/ttl/f": true}, deleted)
+	w.Close()
+
+	_, err = ovs.DeleteKV("includes", "/ttl")
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestLock(t *testing.T) {
+	fmt.Println("Verify Mutex, Election and fencing")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	other, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	// ttl too short to keep the lease alive is refused, not panicked on
+	assert.NotEqual(t, nil, ovs.Mutex("/lock/z", 0).TryLock())
+	assert.NotEqual(t, nil, ovs.Mutex("/lock/z", time.Nanosecond).Lock(context.Background()))
+	assert.NotEqual(t, nil, ovs.Election("/lock/e", 0).Campaign(context.Background(), "z"))
+
+	a := ovs.Mutex("/lock/m", 300*time.Millisecond)
+	b := other.Mutex("/lock/m", 300*time.Millisecond)
+	assert.Equal(t, nil, a.TryLock())
+	assert.Equal(t, ovskv.ErrLocked, b.TryLock())
+
+	err = a.Fence(ovs.Begin().Set("/lock/x", "a")).Commit()
+	assert.Equal(t, err, nil)
+	err = b.Fence(other.Begin().Set("/lock/x", "b")).Commit()
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotLocked))
+
+	done := a.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		a.Unlock()
+	}()
+	assert.Equal(t, nil, b.Lock(ctx))
+	<-done
+
+	// lock lost behind the holder's back fails its fenced writes
+	rows, err := ovs.GetKV("==", "/lock/m")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, nil, ovs.Revoke(rows[0]["value"]))
+	err = b.Fence(other.Begin().Set("/lock/x", "b")).Commit()
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotLocked))
+	select {
+	case <-b.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost lock not noticed")
+	}
+
+	e1 := ovs.Election("/lock/e", 300*time.Millisecond)
+	e2 := other.Election("/lock/e", 300*time.Millisecond)
+	assert.Equal(t, nil, e1.Campaign(ctx, "n1"))
+	leader, err := e2.Leader()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "n1", leader)
+
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	assert.Equal(t, context.DeadlineExceeded, e2.Campaign(short, "n2"))
+
+	assert.Equal(t, nil, e1.Resign())
+	assert.Equal(t, nil, e2.Campaign(ctx, "n2"))
+	leader, err = e1.Leader()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "n2", leader)
+	assert.Equal(t, nil, e2.Resign())
+
+	_, err = ovs.DeleteKV("includes", "/lock")
+	assert.Equal(t, err, nil)
+
+	other.Disconnect()
+        ovs.Disconnect()
+}
+
+func TestCAS(t *testing.T) {
+	fmt.Println("Verify compare-and-swap on key revisions")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	rev, err := ovs.SetKVIfVersion("/cas", "a", 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uint32(1), rev)
+
+	_, err = ovs.SetKVIfVersion("/cas", "a", 0)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+
+	rows, err := ovs.GetKV("==", "/cas")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "1", rows[0]["revision"])
+
+	_, err = ovs.SetKV("/cas", "b")
+	assert.Equal(t, err, nil)
+
+	// revision 1 is stale now
+	_, err = ovs.SetKVIfVersion("/cas", "c", rev)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+
+	err = ovs.DeleteKVIfVersion("/cas", rev)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+
+	// zero version means the key must not exist
+	err = ovs.DeleteKVIfVersion("/cas", 0)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+
+	rev, err = ovs.SetKVIfVersion("/cas", "c", 2)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, uint32(3), rev)
+
+	err = ovs.DeleteKVIfVersion("/cas", rev)
+	assert.Equal(t, err, nil)
+
+	rows, err = ovs.GetKV("==", "/cas")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	err = ovs.DeleteKVIfVersion("/cas", 0)
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestCtx(t *testing.T) {
+	fmt.Println("Verify done context aborts calls")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	var kv ovskv.OvsKVCtx = ovs
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_, err = kv.SetKVCtx(ctx, "/ctx", "a")
+	assert.Equal(t, err, nil)
+	rows, err := kv.GetKVCtx(ctx, "==", "/ctx")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, len(rows))
+	cancel()
+
+	_, err = kv.SetKVCtx(ctx, "/ctx", "b")
+	assert.Equal(t, true, errors.Is(err, context.Canceled))
+	_, err = kv.DeleteKVCtx(ctx, "==", "/ctx")
+	assert.Equal(t, true, errors.Is(err, context.Canceled))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	err = ovs.Begin().Set("/ctx", "c").CommitCtx(ctx)
+	assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+
+	rows, err = ovs.GetKV("==", "/ctx")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "a", rows[0]["value"])
+
+	_, err = ovs.DeleteKV("==", "/ctx")
+	assert.Equal(t, err, nil)
+
+	// nothing of the structure gets saved with done context
+	a := D{Name: "ctx"}
+	ovs2, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &a)
+	assert.Equal(t, err, nil)
+	err = ovs2.SaveCtx(ctx)
+	assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+	err = ovs2.LoadCtx(ctx)
+	assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+	rows, err = ovs.GetKV("==", "/name")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	ovs2.Disconnect()
+        ovs.Disconnect()
+}
+
+func TestList(t *testing.T) {
+	fmt.Println("Verify List pages through keys in order and ListChildren")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	for _, key := range []string{"/tenants/t3", "/tenants/t1", "/tenants/t5", "/tenants/t2", "/tenants/t4", "/tenants/t3/x"} {
+		_, err = ovs.SetKV(key, key)
+		assert.Equal(t, err, nil)
+	}
+
+	var keys []string
+	opts := ovskv.ListOptions{Limit: 4}
+	pages := 0
+	for {
+		res, err := ovs.List("/tenants", opts)
+		assert.Equal(t, err, nil)
+		for _, item := range res.Items {
+			keys = append(keys, item.Key)
+			assert.Equal(t, item.Key, item.Data["v"])
+		}
+		pages++
+		if res.Continue == "" {
+			break
+		}
+		opts.Continue = res.Continue
+	}
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, []string{"/tenants/t1", "/tenants/t2", "/tenants/t3", "/tenants/t3/x", "/tenants/t4", "/tenants/t5"}, keys)
+
+	res, err := ovs.List("/tenants/t3", ovskv.ListOptions{KeysOnly: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(res.Items))
+	assert.Equal(t, ovskv.OvsKVMap(nil), res.Items[0].Data)
+
+	_, err = ovs.List("/tenants", ovskv.ListOptions{Continue: "!"})
+	assert.NotEqual(t, err, nil)
+
+	children, err := ovs.ListChildren("/tenants")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, len(children))
+	assert.Equal(t, ovskv.Child{Name: "t1", Key: "/tenants/t1", Leaf: true}, children[0])
+	assert.Equal(t, ovskv.Child{Name: "t3", Key: "/tenants/t3", Leaf: false}, children[2])
+
+	children, err = ovs.ListChildren("/tenants/t3/x")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(children))
+	children, err = ovs.ListChildren("/tenants/t1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(children))
+
+	// failed request is an error, not a key without children
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ovs.ListChildrenCtx(ctx, "/tenants")
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = ovs.DeleteKV("includes", "/tenants")
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestExists(t *testing.T) {
+	fmt.Println("Verify Exists, Count and existence preconditions")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKV("/exists/a", "1")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/exists/b", "2")
+	assert.Equal(t, err, nil)
+
+	found, err := ovs.Exists("/exists/a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, true, found)
+	found, err = ovs.Exists("/exists/c")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, false, found)
+
+	count, err := ovs.Count("includes", "/exists")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, count)
+
+	err = ovs.Begin().IfExists("/exists/a").IfNotExists("/exists/c").Set("/exists/c", "3").Commit()
+	assert.Equal(t, err, nil)
+	err = ovs.Begin().IfExists("/exists/d").Set("/exists/b", "x").Commit()
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotFound))
+	err = ovs.Begin().IfNotExists("/exists/a").Set("/exists/b", "x").Commit()
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrKeyExists))
+
+	rows, err := ovs.GetKV("==", "/exists/b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "2", rows[0]["value"])
+
+	count, err = ovs.DeleteKV("includes", "/exists")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 3, count)
+
+        ovs.Disconnect()
+}
+
+func TestExportImport(t *testing.T) {
+	fmt.Println("Export subtree as nested document and import it elsewhere")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKV("/exp/a", "top")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/exp/a/b", "1")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVM("/exp/a/c", map[string]string{"x": "1", "y": "2"})
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/exp/d", "3")
+	assert.Equal(t, err, nil)
+
+	doc, err := ovs.Export("/exp")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{
+			"=": "top",
+			"b": "1",
+			"c": map[string]interface{}{"=": map[string]interface{}{"x": "1", "y": "2"}},
+		},
+		"d": "3",
+	}, doc)
+	leaf, err := ovs.Export("/exp/a/b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "1", leaf)
+	_, err = ovs.Export("/exp/none")
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotFound))
+
+	// as it comes back from JSON
+	b, err := json.Marshal(doc)
+	assert.Equal(t, err, nil)
+	var decoded interface{}
+	assert.Equal(t, nil, json.Unmarshal(b, &decoded))
+	assert.Equal(t, nil, ovs.Import("/imp", decoded))
+	copied, err := ovs.Export("/imp")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, doc, copied)
+
+	err = ovs.Import("/imp", map[string]interface{}{"n": 1.5, "t": true, "e": []interface{}{"x"}})
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, nil, ovs.Import("/imp", map[string]interface{}{"n": 1.5, "t": true}))
+	rows, err := ovs.GetKV("==", "/imp/n")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "1.5", rows[0]["value"])
+	assert.Equal(t, nil, ovs.Import("", map[string]interface{}{"imp": map[string]interface{}{"u": "v"}}))
+	exists, err := ovs.Exists("/imp/u")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, true, exists)
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+        ovs.Disconnect()
+}
+
+func TestSnapshot(t *testing.T) {
+	fmt.Println("Snapshot namespace, change it and restore it back")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKV("/snap/a", "1")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKVM("/snap/b/c", map[string]string{"x": "1", "y": "2"})
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/other", "o")
+	assert.Equal(t, err, nil)
+	// relative keys are part of the namespace too
+	_, err = ovs.SetKV("rel", "r")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("rel/a", "ra")
+	assert.Equal(t, err, nil)
+
+	var snapshot strings.Builder
+	n, err := ovs.Snapshot(&snapshot)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, n)
+	before, err := ovs.Export("")
+	assert.Equal(t, err, nil)
+
+	// a bad config push
+	_, err = ovs.SetKV("/snap/a", "2")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/snap/new", "n")
+	assert.Equal(t, err, nil)
+	_, err = ovs.DeleteKV("==", "/other")
+	assert.Equal(t, err, nil)
+	_, err = ovs.DeleteKV("==", "rel/a")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("rel/new", "n")
+	assert.Equal(t, err, nil)
+	rows, err := ovs.GetKV("==", "/snap/a")
+	assert.Equal(t, err, nil)
+	stale := rows[0]["revision"]
+
+	n, err = ovs.Restore(strings.NewReader(snapshot.String()), ovskv.RestoreOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, n)
+	after, err := ovs.Export("")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, before, after)
+	rows, err = ovs.GetKV("includes", "rel")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+	count, err := ovs.Count("==", "rel/new")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, count)
+	rows, err = ovs.GetKV("==", "/snap/a")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, stale, rows[0]["revision"])
+
+	// subtree restored elsewhere, the rest is kept
+	n, err = ovs.Restore(strings.NewReader(snapshot.String()), ovskv.RestoreOptions{From: "/snap", To: "/copy"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, n)
+	copied, err := ovs.Export("/copy")
+	assert.Equal(t, err, nil)
+	snapped, err := ovs.Export("/snap")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, snapped, copied)
+	count, err = ovs.Count("includes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, count)
+	count, err = ovs.Count("includes", "rel")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, count)
+
+	// subtree restored in place, keys outside of it are kept
+	_, err = ovs.SetKV("/snap/a", "3")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/snap/extra", "e")
+	assert.Equal(t, err, nil)
+	n, err = ovs.Restore(strings.NewReader(snapshot.String()), ovskv.RestoreOptions{From: "/snap"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, n)
+	restored, err := ovs.Export("/snap")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, snapped, restored)
+	count, err = ovs.Count("includes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, count)
+	count, err = ovs.Count("includes", "rel")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, count)
+
+	damaged := strings.Replace(snapshot.String(), `"v":"1"`, `"v":"9"`, 1)
+	_, err = ovs.Restore(strings.NewReader(damaged), ovskv.RestoreOptions{})
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSnapshot))
+	_, err = ovs.Restore(strings.NewReader("{}"), ovskv.RestoreOptions{})
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSnapshot))
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+        ovs.Disconnect()
+}
+
+func TestDeleteTree(t *testing.T) {
+	fmt.Println("Verify DeleteTree keeps sibling subtrees and DeleteAll is confirmed")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	for _, key := range []string{"/tree/a", "/tree/a/b", "/tree/a/b/c", "/tree/ab", "/tree/ab/c"} {
+		_, err = ovs.SetKV(key, key)
+		assert.Equal(t, err, nil)
+	}
+
+	keys, err := ovs.DeleteTree("/tree/a", ovskv.DeleteOptions{DryRun: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, []string{"/tree/a", "/tree/a/b", "/tree/a/b/c"}, keys)
+	count, err := ovs.Count("includes", "/tree")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, count)
+
+	_, err = ovs.DeleteTree("/tree/a", ovskv.DeleteOptions{MaxRows: 2})
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrTooManyKeys))
+
+	keys, err = ovs.DeleteTree("/tree/a", ovskv.DeleteOptions{MaxRows: 3})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 3, len(keys))
+	rows, err := ovs.GetKV("includes", "/tree")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	_, err = ovs.DeleteTree("/", ovskv.DeleteOptions{})
+	assert.NotEqual(t, err, nil)
+	_, err = ovs.DeleteAll("")
+	assert.NotEqual(t, err, nil)
+
+	// relative keys are part of the namespace too
+	for _, key := range []string{"tree", "tree/a"} {
+		_, err = ovs.SetKV(key, key)
+		assert.Equal(t, err, nil)
+	}
+	count, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 4, count)
+	count, err = ovs.Count("excludes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, count)
+
+        ovs.Disconnect()
+}
+
+func TestSchema(t *testing.T) {
+	fmt.Println("Verify shard tables are checked against the schema")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	load := func() *libovsdb.DatabaseSchema {
+		b, err := os.ReadFile("testkv.ovsschema")
+		assert.Equal(t, err, nil)
+		var schema libovsdb.DatabaseSchema
+		assert.Equal(t, nil, json.Unmarshal(b, &schema))
+		return &schema
+	}
+	assert.Equal(t, nil, ovs.CheckSchema(load()))
+
+	schema := load()
+	table := schema.Tables["Zone_1"]
+	table.Indexes = nil
+	schema.Tables["Zone_1"] = table
+	err = ovs.CheckSchema(schema)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSchema))
+	assert.Equal(t, true, strings.Contains(err.Error(), "no index of column path"))
+
+	schema = load()
+	schema.Tables["Zone_1"].Columns["data"] = libovsdb.ColumnSchema{Type: map[string]interface{}{"key": "string", "max": "unlimited"}}
+	err = ovs.CheckSchema(schema)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSchema))
+	assert.Equal(t, true, strings.Contains(err.Error(), "column data is set of string, expected map of string to string"))
+
+	schema = load()
+	delete(schema.Tables, "Zone_1")
+	assert.Equal(t, true, errors.Is(ovs.CheckSchema(schema), ovskv.ErrSchema))
+
+	assert.Equal(t, true, errors.Is(ovs.SetColumns(ovskv.Columns{Path: "key"}), ovskv.ErrSchema))
+	assert.Equal(t, true, errors.Is(ovs.SetColumns(ovskv.Columns{Extra: map[string]string{"owner": "string"}}), ovskv.ErrSchema))
+	assert.Equal(t, true, errors.Is(ovs.SetColumns(ovskv.Columns{Extra: map[string]string{"owner": "text"}}), ovskv.ErrSchema))
+	_, err = ovs.SetKVMWithColumns("/schema/a", ovs.V("a"), map[string]interface{}{"owner": "ops"})
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSchema))
+	assert.Equal(t, nil, ovs.SetColumns(ovskv.Columns{}))
+
+        ovs.Disconnect()
+}
+
+func TestMigrate(t *testing.T) {
+	fmt.Println("Generate schema and verify the served one needs no conversion")
+	decode := func(b []byte) interface{} {
+		var doc interface{}
+		assert.Equal(t, nil, json.Unmarshal(b, &doc))
+		return doc
+	}
+	expected, err := os.ReadFile("testkv.ovsschema")
+	assert.Equal(t, err, nil)
+	generated, err := ovskv.GenerateSchema(DB_NAME, DB_NAMESPACE, 4, ovskv.Columns{})
 	assert.Equal(t, err, nil)
-	_, err = ovs.SetKV("Test1/Tenants/Foo/Chassis/1/NetInterfaces/1", "val1")
+	assert.Equal(t, decode(expected), decode(generated))
+
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
 	assert.Equal(t, err, nil)
-	_, err = ovs.SetKV("Test1/Tenants/Foo/Chassis/1/NetInterfaces/2", "val2")
+	generated, err = ovskv.GenerateSchema(DB_NAME, DB_NAMESPACE, 2, ovskv.Columns{Extra: map[string]string{"owner": "string"}})
 	assert.Equal(t, err, nil)
-	_, err = ovs.SetKVM("Test2", map[string]string{"Description":"desc1"})
+	var schema libovsdb.DatabaseSchema
+	assert.Equal(t, nil, json.Unmarshal(generated, &schema))
+	assert.Equal(t, nil, ovs.CheckSchema(&schema))
+	_, err = ovskv.GenerateSchema(DB_NAME, DB_NAMESPACE, 2, ovskv.Columns{Extra: map[string]string{"owner": "text"}})
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrSchema))
+
+	version, err := ovskv.SchemaVersion(DB_NAME, DB_CONNECT)
 	assert.Equal(t, err, nil)
-	_, err = ovs.SetKVM("Test2/Tenants/Foo", map[string]string{"Description":"desc3","par1":"val1"})
+	assert.Equal(t, ovskv.SCHEMA_VERSION, version)
+	converted, err := ovskv.Migrate(DB_NAME, DB_CONNECT, DB_NAMESPACE, ovskv.MigrateOptions{Shards: 4, DryRun: true})
 	assert.Equal(t, err, nil)
+	assert.Equal(t, false, converted)
 
-	rows, err = ovs.GetKV("includes", "Test1/Tenants/Foo/Chassis/1")
-	found := false
-	for i, _ := range rows {
-		if rows[i]["key"] == "Test1/Tenants/Foo/Chassis/1/NetInterfaces/2" {
-			found = true
-			break
+	// ssl: remote is dialed with TLS, first byte of TLS handshake is 0x16
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, err, nil)
+	first := make(chan byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(first)
+			return
 		}
-	}
-	assert.Equal(t, true, found)
+		b := make([]byte, 1)
+		conn.Read(b)
+		first <- b[0]
+		conn.Close()
+	}()
+	_, err = ovskv.SchemaVersion(DB_NAME, "ssl:"+l.Addr().String())
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, byte(0x16), <-first)
+	l.Close()
 
-	rows, err = ovs.GetKV("includes", "Test2")
-	found = false
-	for i, _ := range rows {
-		if rows[i]["key"] == "Test2/Tenants/Foo" {
-			found = true
-			break
-		}
+        ovs.Disconnect()
+}
+
+func TestShards(t *testing.T) {
+	fmt.Println("Verify keys distributed across shards are found by prefix queries")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+	ovs.SetShards(4)
+
+	for i := 0; i < 8; i++ {
+		_, err = ovs.SetKV(fmt.Sprintf("/s%d/a", i), strconv.Itoa(i))
+		assert.Equal(t, err, nil)
+		_, err = ovs.SetKV(fmt.Sprintf("/s%d/b", i), strconv.Itoa(i))
+		assert.Equal(t, err, nil)
 	}
-	assert.Equal(t, true, found)
 
-	rows, err = ovs.GetKV("==", "Test1/Tenants/Foo/Chassis/1/NetInterfaces/1")
-	assert.Equal(t, 1, len(rows))
+	rows, err := ovs.GetKV("includes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 16, len(rows))
 
-	_, err = ovs.DeleteKV("excludes", "")
+	rows, err = ovs.GetKV("includes", "/s3")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 2, len(rows))
+
+	rows, err = ovs.GetKV("==", "/s5/b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "5", rows[0]["value"])
+
+	err = ovs.Begin().Delete("includes", "/s1").Set("/s2/a", "x").Commit()
 	assert.Equal(t, err, nil)
 
-	_, err = ovs.DeleteKV("includes", "")
+	count, err := ovs.DeleteAll(DB_NAMESPACE)
 	assert.Equal(t, err, nil)
+	assert.Equal(t, 14, count)
 
         ovs.Disconnect()
 }
@@ -225,7 +1174,382 @@ func TestSaveAndLoad(t *testing.T) {
 	assert.Equal(t, 1, b.Field13["k 1"])
 	assert.Equal(t, 2, b.Field13["k 2"])
 
-	_, err = ovs.DeleteKV("includes", "")
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type L struct {
+	Name  string `ovskv:"name"`
+	Num   int    `ovskv:"num"`
+	Extra string `ovskv:"extra"`
+}
+
+func TestLoadErrors(t *testing.T) {
+	fmt.Println("Load struct with missing and unparseable keys")
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(t, err, nil)
+
+	_, err = ovs.SetKV("/le/name", "n")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/le/num", "abc")
+	assert.Equal(t, err, nil)
+
+	l := L{Extra: "default"}
+	err = ovs.LoadField(&l, "/le")
+	var lerr *ovskv.LoadError
+	assert.Equal(t, true, errors.As(err, &lerr))
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrNotFound))
+	assert.Equal(t, []string{"/le/extra"}, lerr.Missing)
+	assert.Equal(t, 1, len(lerr.Invalid))
+	assert.NotEqual(t, nil, lerr.Invalid["/le/num"])
+	assert.Equal(t, "n", l.Name)
+	assert.Equal(t, "default", l.Extra)
+
+	_, err = ovs.SetKV("/le/num", "5")
+	assert.Equal(t, err, nil)
+
+	ovs.SetAllowMissing(true)
+	err = ovs.LoadField(&l, "/le")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 5, l.Num)
+	assert.Equal(t, "default", l.Extra)
+
+	_, err = ovs.DeleteKV("includes", "/le")
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type V struct {
+	I8    int8                     `ovskv:"i8"`
+	I32   int32                    `ovskv:"i32"`
+	U     uint                     `ovskv:"u"`
+	U16   uint16                   `ovskv:"u16"`
+	U64   uint64                   `ovskv:"u64"`
+	F32   float32                  `ovskv:"f32"`
+	F64   float64                  `ovskv:"f64"`
+	Time  time.Time                `ovskv:"time"`
+	Dur   time.Duration            `ovskv:"dur"`
+	Bytes []byte                   `ovskv:"bytes"`
+	IP    net.IP                   `ovskv:"ip"`
+	Net   net.IPNet                `ovskv:"net"`
+	Times []time.Time              `ovskv:"times"`
+	Rates map[string]float64       `ovskv:"rates"`
+	Nets  map[string]net.IPNet     `ovskv:"nets"`
+}
+
+type VS struct {
+	Values V `ovskv:"values"`
+}
+
+func TestValueTypes(t *testing.T) {
+	fmt.Println("Save and load numeric, time, bytes and address fields")
+	_, ipNet, _ := net.ParseCIDR("10.1.0.0/16")
+	now := time.Now().UTC()
+	vs := VS{Values: V{
+		I8:    -8,
+		I32:   -32,
+		U:     1,
+		U16:   65535,
+		U64:   1 << 63,
+		F32:   0.1,
+		F64:   3.141592653589793,
+		Time:  now,
+		Dur:   90 * time.Second,
+		Bytes: []byte{0, 1, 2, 255},
+		IP:    net.ParseIP("fe80::1"),
+		Net:   *ipNet,
+		Times: []time.Time{now, now.Add(time.Hour)},
+		Rates: map[string]float64{"in": 0.5, "out": 1e-9},
+		Nets:  map[string]net.IPNet{"a": *ipNet},
+	}}
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &vs)
+	assert.Equal(t, err, nil)
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+
+	rows, err := ovs.GetKV("==", "/values/dur")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "1m30s", rows[0]["value"])
+
+	loaded := VS{}
+	err = ovs.LoadField(&loaded, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, vs, loaded)
+
+	_, err = ovs.SetKV("/values/u16", "65536")
+	assert.Equal(t, err, nil)
+	err = ovs.LoadField(&loaded, "")
+	var lerr *ovskv.LoadError
+	assert.Equal(t, true, errors.As(err, &lerr))
+	assert.NotEqual(t, nil, lerr.Invalid["/values/u16"])
+
+	_, err = ovs.DeleteTree("/values", ovskv.DeleteOptions{})
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type Section struct {
+	Name string  `ovskv:"name"`
+	MTU  *int    `ovskv:"mtu"`
+}
+
+type Layout struct {
+	Label    *string            `ovskv:"label"`
+	Uplink   *Section           `ovskv:"uplink"`
+	Overlay  *Section           `ovskv:"overlay"`
+	Tags     map[string]string  `ovskv:"tags"`
+	Ports    []int              `ovskv:"ports"`
+	Sections map[string]*Section `ovskv:"sections"`
+}
+
+type LS struct {
+	Layout Layout `ovskv:"layout"`
+}
+
+func TestPointers(t *testing.T) {
+	fmt.Println("Save nil pointers, maps and slices as absent keys and load them back as nil")
+	label := "dc1"
+	mtu := 9000
+	ls := LS{Layout: Layout{
+		Label:    &label,
+		Uplink:   &Section{Name: "eth0", MTU: &mtu},
+		Tags:     map[string]string{},
+		Sections: map[string]*Section{"a": {Name: "a"}},
+	}}
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &ls)
+	assert.Equal(t, err, nil)
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+
+	loaded := LS{Layout: Layout{Overlay: &Section{Name: "stale"}, Ports: []int{1}}}
+	err = ovs.LoadField(&loaded, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ls.Layout.Sections, loaded.Layout.Sections)
+	assert.Equal(t, ls.Layout.Uplink, loaded.Layout.Uplink)
+	assert.Equal(t, (*Section)(nil), loaded.Layout.Overlay)
+	assert.Equal(t, []int(nil), loaded.Layout.Ports)
+	assert.Equal(t, map[string]string(nil), loaded.Layout.Tags)
+
+	// set to nil, keys go away
+	ls.Layout.Uplink = nil
+	ls.Layout.Label = nil
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+	count, err := ovs.Count("includes", "/layout/uplink")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, count)
+
+	ls.Layout.Tags = nil
+	loaded = LS{}
+	err = ovs.LoadField(&loaded, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ls, loaded)
+
+	_, err = ovs.DeleteTree("/layout", ovskv.DeleteOptions{})
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type Mode int
+
+const (
+	ModeOff Mode = iota
+	ModeOn
+)
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte([]string{"off", "on"}[m]), nil
+}
+
+func (m *Mode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "off":
+		*m = ModeOff
+	case "on":
+		*m = ModeOn
+	default:
+		return fmt.Errorf("invalid mode %q", text)
+	}
+	return nil
+}
+
+type Endpoint struct {
+	Host string
+	Port int
+}
+
+func (e Endpoint) MarshalOvsKV() (map[string]string, error) {
+	return map[string]string{"host": e.Host, "port": strconv.Itoa(e.Port)}, nil
+}
+
+func (e *Endpoint) UnmarshalOvsKV(data map[string]string) error {
+	port, err := strconv.Atoi(data["port"])
+	if err != nil {
+		return err
+	}
+	e.Host, e.Port = data["host"], port
+	return nil
+}
+
+type M struct {
+	Mode      Mode                `ovskv:"mode"`
+	Modes     map[string]Mode     `ovskv:"modes"`
+	Endpoint  Endpoint            `ovskv:"endpoint"`
+	Endpoints map[string]Endpoint `ovskv:"endpoints"`
+}
+
+type MS struct {
+	M M `ovskv:"marshal"`
+}
+
+func TestMarshalers(t *testing.T) {
+	fmt.Println("Save and load types with their own marshalers")
+	ms := MS{M: M{
+		Mode:      ModeOn,
+		Modes:     map[string]Mode{"a": ModeOff, "b": ModeOn},
+		Endpoint:  Endpoint{Host: "10.0.0.1", Port: 6641},
+		Endpoints: map[string]Endpoint{"sb": {Host: "10.0.0.2", Port: 6642}},
+	}}
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &ms)
+	assert.Equal(t, err, nil)
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+
+	rows, err := ovs.GetKV("==", "/marshal/mode")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "on", rows[0]["value"])
+	m, err := ovs.GetKVM("==", "/marshal/endpoints/sb")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 1, len(*m))
+
+	loaded := MS{}
+	err = ovs.LoadField(&loaded, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ms, loaded)
+
+	_, err = ovs.SetKV("/marshal/mode", "auto")
+	assert.Equal(t, err, nil)
+	err = ovs.LoadField(&loaded, "")
+	var lerr *ovskv.LoadError
+	assert.Equal(t, true, errors.As(err, &lerr))
+	assert.NotEqual(t, nil, lerr.Invalid["/marshal/mode"])
+
+	_, err = ovs.DeleteTree("/marshal", ovskv.DeleteOptions{})
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type Meta struct {
+	Owner string `ovskv:"owner"`
+	Zone  string `ovskv:"zone"`
+}
+
+type Opts struct {
+	Meta   `ovskv:",inline"`
+	Name   string `ovskv:"name,omitempty"`
+	Prio   int    `ovskv:"prio,default=100"`
+	Status string `ovskv:"status,readonly"`
+}
+
+type OS struct {
+	Opts Opts `ovskv:"opts"`
+}
+
+func TestTagOptions(t *testing.T) {
+	fmt.Println("Verify omitempty, default, readonly and inline tag options")
+	opts := OS{Opts: Opts{Meta: Meta{Owner: "ops", Zone: "z1"}, Prio: 5, Status: "ignored"}}
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &opts)
+	assert.Equal(t, err, nil)
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+
+	rows, err := ovs.GetKV("includes", "/opts")
+	assert.Equal(t, err, nil)
+	keys := make(map[string]string)
+	for _, row := range rows {
+		keys[row["key"]] = row["value"]
+	}
+	assert.Equal(t, map[string]string{"/opts/owner": "ops", "/opts/zone": "z1", "/opts/prio": "5"}, keys)
+
+	_, err = ovs.DeleteKV("==", "/opts/prio")
+	assert.Equal(t, err, nil)
+	_, err = ovs.SetKV("/opts/status", "up")
+	assert.Equal(t, err, nil)
+
+	loaded := OS{Opts: Opts{Name: "stale"}}
+	err = ovs.LoadField(&loaded, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, Opts{Meta: Meta{Owner: "ops", Zone: "z1"}, Prio: 100, Status: "up"}, loaded.Opts)
+
+	type Bad struct {
+		Name string `ovskv:"name,omitempt"`
+	}
+	err = ovs.LoadField(&Bad{}, "/opts")
+	assert.NotEqual(t, err, nil)
+
+	_, err = ovs.DeleteTree("/opts", ovskv.DeleteOptions{})
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+func TestSaveTxn(t *testing.T) {
+	fmt.Println("Save Go struct in one transaction")
+	a := A{
+		Field1: "value1",
+		Field3: 123,
+		Field5: B{
+			SubField1: "value1",
+		},
+		Field7: []B{
+			{
+				SubField1: "value1-B0",
+			},
+		},
+		Field12: map[string]string{"key 1":"value1"},
+	}
+
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &a)
+	assert.Equal(t, err, nil)
+
+	txn := ovs.Begin()
+	err = txn.Save()
+	assert.Equal(t, err, nil)
+
+	// nothing is stored before commit
+	rows, err := ovs.GetKV("includes", "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, 0, len(rows))
+
+	err = txn.Commit()
+	assert.Equal(t, err, nil)
+
+	a.Field1 = "value1 changed"
+	txn = ovs.Begin()
+	err = txn.SaveField(&a.Field1)
+	assert.Equal(t, err, nil)
+	err = txn.Commit()
+	assert.Equal(t, err, nil)
+
+	rows, err = ovs.GetKV("==", "/field1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "value1 changed", rows[0]["value"])
+
+	rows, err = ovs.GetKV("==", "/field3")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "123", rows[0]["value"])
+
+	rows, err = ovs.GetKV("==", "/field7/0/subfield1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "value1-B0", rows[0]["value"])
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
 	assert.Equal(t, err, nil)
 
         ovs.Disconnect()
@@ -257,9 +1581,69 @@ func TestSaveField(t *testing.T) {
 	assert.Equal(t, err, nil)
 	assert.Equal(t, rows[0]["value"], "value1 changed3")
 
-	_, err = ovs.DeleteKV("includes", "")
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+        ovs.Disconnect()
+}
+
+type D struct {
+	Name string `ovskv:"name"`
+	Sub  B      `ovskv:"sub"`
+}
+
+func TestSaveFieldIfUnchanged(t *testing.T) {
+	fmt.Println("Load Go struct twice, save first copy and verify the second one conflicts")
+	a := D{
+		Name: "value1",
+		Sub: B{
+			SubField1: "value1",
+		},
+	}
+
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &a)
+	assert.Equal(t, err, nil)
+	err = ovs.Save()
+	assert.Equal(t, err, nil)
+
+	var b, c D
+	ovsB, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &b)
+	assert.Equal(t, err, nil)
+	err = ovsB.Load()
+	assert.Equal(t, err, nil)
+
+	ovsC, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, &c)
+	assert.Equal(t, err, nil)
+	err = ovsC.Load()
+	assert.Equal(t, err, nil)
+
+	b.Sub.SubField1 = "changed by b"
+	err = ovsB.SaveFieldIfUnchanged(&b.Sub)
+	assert.Equal(t, err, nil)
+
+	// saving again uses revision of the previous save
+	b.Sub.SubField1 = "changed by b again"
+	err = ovsB.SaveFieldIfUnchanged(&b.Sub)
+	assert.Equal(t, err, nil)
+
+	c.Sub.SubField1 = "changed by c"
+	err = ovsC.SaveFieldIfUnchanged(&c.Sub)
+	assert.Equal(t, true, errors.Is(err, ovskv.ErrConflict))
+
+	// unrelated field is still at the loaded revision
+	c.Name = "changed by c"
+	err = ovsC.SaveFieldIfUnchanged(&c.Name)
 	assert.Equal(t, err, nil)
 
+	rows, err := ovs.GetKV("==", "/sub/subfield1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, "changed by b again", rows[0]["value"])
+
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
+	assert.Equal(t, err, nil)
+
+        ovsC.Disconnect()
+        ovsB.Disconnect()
         ovs.Disconnect()
 }
 
@@ -326,7 +1710,7 @@ func BenchmarkStructSave(b *testing.B) {
 
 	b.StopTimer()
 
-	_, err = ovs.DeleteKV("includes", "")
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
 	assert.Equal(b, err, nil)
 
         ovs.Disconnect()
@@ -353,7 +1737,7 @@ func BenchmarkStructLoad(b *testing.B) {
 
 	b.StopTimer()
 
-	_, err = ovs.DeleteKV("includes", "")
+	_, err = ovs.DeleteAll(DB_NAMESPACE)
 	assert.Equal(b, err, nil)
 
         ovs.Disconnect()
@@ -429,3 +1813,33 @@ func BenchmarkKVMGet(b *testing.B) {
 
         ovs.Disconnect()
 }
+
+func BenchmarkKVMGetCached(b *testing.B) {
+	ovs, err := ovskv.Init(DB_NAME, DB_CONNECT, DB_NAMESPACE, nil)
+	assert.Equal(b, err, nil)
+	err = ovs.SetCache(true)
+	assert.Equal(b, err, nil)
+
+	for i := 0; i < b.N; i++ {
+		_, err := ovs.InsertKV(fmt.Sprintf("%d", i), strconv.Itoa(i))
+		if err != nil && strings.Index(fmt.Sprintf("%v", err), "constraint violation") != -1 {
+			continue
+		}
+		assert.Equal(b, err, nil)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := ovs.GetKVM("==", fmt.Sprintf("%d", i))
+		assert.Equal(b, err, nil)
+	}
+
+	b.StopTimer()
+
+	_, err = ovs.DeleteKV("excludes", "")
+	assert.Equal(b, err, nil)
+
+        ovs.Disconnect()
+}
@@ -0,0 +1,396 @@
+package ovskv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ebay/libovsdb"
+)
+
+const (
+	PATH_COLUMN string = "path"
+	DATA_COLUMN string = "data"
+)
+
+// Columns names columns of the shard tables
+type Columns struct {
+	Path  string            // set of index prefixed key components, with unique index
+	Data  string            // map of strings holding value of the key
+	Extra map[string]string // additional columns to their OVSDB atomic type, e.g. "owner": "string"
+}
+
+// columnType is OVSDB type of a column reduced to what is checked, value
+// is empty for scalars and sets
+type columnType struct {
+	key   string
+	value string
+	max   int // -1 for unlimited
+}
+
+func (t columnType) String() string {
+	switch {
+	case t.value != "":
+		return fmt.Sprintf("map of %s to %s", t.key, t.value)
+	case t.max != 1:
+		return fmt.Sprintf("set of %s", t.key)
+	}
+	return t.key
+}
+
+// parseColumnType reduces "type" of the column schema, either an atomic
+// type name or an object with key, value and max
+func parseColumnType(t interface{}) columnType {
+	ct := columnType{max: 1}
+	switch v := t.(type) {
+	case string:
+		ct.key = v
+	case map[string]interface{}:
+		ct.key = baseType(v["key"])
+		ct.value = baseType(v["value"])
+		switch max := v["max"].(type) {
+		case float64:
+			ct.max = int(max)
+		case string:
+			if max == "unlimited" {
+				ct.max = -1
+			}
+		}
+	}
+	return ct
+}
+
+// compatible reports whether column of type t can hold values of type
+// want, sets are fine as long as they are not limited to fewer elements
+// than needed
+func (t columnType) compatible(want columnType) bool {
+	return t.key == want.key && t.value == want.value &&
+		(want.max != -1 || t.max == -1) && (want.max != 1 || t.max == 1)
+}
+
+func baseType(t interface{}) string {
+	switch v := t.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		s, _ := v["type"].(string)
+		return s
+	}
+	return ""
+}
+
+// SetColumns maps OvsKV to shard tables with other column names or with
+// extra columns, see SetKVMWithColumns. Empty names are left at default.
+// It has to be called before any other call, fails with ErrSchema if the
+// database does not have the columns. Init of a database without path or
+// data column succeeds, calls fail with ErrSchema until SetColumns does.
+func (o *OvsKVImpl) SetColumns(columns Columns) error {
+	columns, err := normalizeColumns(columns)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSchema(o.shards, columns); err != nil {
+		return err
+	}
+	o.columns = columns
+	o.schemaErr = nil
+	return nil
+}
+
+// normalizeColumns fills in default names and checks types of the extra
+// columns
+func normalizeColumns(columns Columns) (Columns, error) {
+	if columns.Path == "" {
+		columns.Path = PATH_COLUMN
+	}
+	if columns.Data == "" {
+		columns.Data = DATA_COLUMN
+	}
+	for name, typ := range columns.Extra {
+		if _, ok := atomicTypes[typ]; !ok {
+			return columns, fmt.Errorf("%w: column %s of unknown type %s\n", ErrSchema, name, typ)
+		}
+	}
+	return columns, nil
+}
+
+// atomicTypes are OVSDB atomic types allowed for extra columns
+var atomicTypes = map[string]bool{
+	"string":  true,
+	"integer": true,
+	"real":    true,
+	"boolean": true,
+	"uuid":    true,
+}
+
+// schema returns schema of the database as the connected server has it
+func (o *OvsKVImpl) schema() (*libovsdb.DatabaseSchema, bool) {
+	switch c := o.client().(type) {
+	case *libovsdb.OvsdbClient:
+		schema, ok := c.Schema[o.db_name]
+		return &schema, ok
+	case *memSession:
+		return memSchema(o.db_name, append(o.shardTables(), o.leaseTable())), true
+	}
+	return nil, false
+}
+
+// checkSchema verifies the database has shard tables as configured
+func (o *OvsKVImpl) checkSchema(shards int, columns Columns) error {
+	schema, ok := o.schema()
+	if !ok {
+		return fmt.Errorf("%w: database %s not found\n", ErrSchema, o.db_name)
+	}
+	return o.checkTables(schema, shards, columns)
+}
+
+// customColumns reports whether shard tables lack path or data column,
+// i.e. they have to be mapped by SetColumns
+func (o *OvsKVImpl) customColumns() bool {
+	schema, ok := o.schema()
+	if !ok {
+		return false
+	}
+	table, ok := schema.Tables[o.shardTables()[0]]
+	if !ok {
+		return false
+	}
+	_, path := table.Columns[PATH_COLUMN]
+	_, data := table.Columns[DATA_COLUMN]
+	return !path || !data
+}
+
+// CheckSchema verifies schema has shard tables, and lease table if it has
+// one, compatible with the configuration of OvsKVImpl. Returned error
+// matches ErrSchema and tells the first problem found.
+func (o *OvsKVImpl) CheckSchema(schema *libovsdb.DatabaseSchema) error {
+	return o.checkTables(schema, o.shards, o.columns)
+}
+
+// shardColumns returns columns shard tables are expected to have
+func shardColumns(columns Columns) map[string]columnType {
+	expected := map[string]columnType{
+		columns.Path: {key: "string", max: -1},
+		columns.Data: {key: "string", value: "string", max: -1},
+		"revision":   {key: "integer", max: 1},
+		"expires":    {key: "integer", max: 1},
+		"lease":      {key: "string", max: 1},
+	}
+	for name, typ := range columns.Extra {
+		expected[name] = columnType{key: typ, max: 1}
+	}
+	return expected
+}
+
+// leaseColumns are columns the lease table is expected to have
+var leaseColumns = map[string]columnType{
+	"ttl":     {key: "integer", max: 1},
+	"expires": {key: "integer", max: 1},
+}
+
+func (o *OvsKVImpl) checkTables(schema *libovsdb.DatabaseSchema, shards int, columns Columns) error {
+	expected := shardColumns(columns)
+	for i := 1; i <= shards; i++ {
+		table := o.db_namespace + strconv.Itoa(i)
+		if err := checkTable(schema, table, expected); err != nil {
+			return err
+		}
+		if !hasIndex(schema.Tables[table], columns.Path) {
+			return fmt.Errorf("%w: database %s table %s has no index of column %s\n", ErrSchema, schema.Name, table, columns.Path)
+		}
+	}
+	if _, ok := schema.Tables[o.leaseTable()]; ok {
+		return checkTable(schema, o.leaseTable(), leaseColumns)
+	}
+	return nil
+}
+
+func checkTable(schema *libovsdb.DatabaseSchema, table string, expected map[string]columnType) error {
+	tableSchema, ok := schema.Tables[table]
+	if !ok {
+		return fmt.Errorf("%w: database %s has no table %s\n", ErrSchema, schema.Name, table)
+	}
+	for name, want := range expected {
+		column, ok := tableSchema.Columns[name]
+		if !ok {
+			return fmt.Errorf("%w: database %s table %s has no column %s\n", ErrSchema, schema.Name, table, name)
+		}
+		have := parseColumnType(column.Type)
+		if !have.compatible(want) {
+			return fmt.Errorf("%w: database %s table %s column %s is %s, expected %s\n", ErrSchema, schema.Name, table, name, have, want)
+		}
+	}
+	return nil
+}
+
+func hasIndex(table libovsdb.TableSchema, column string) bool {
+	for _, index := range table.Indexes {
+		if len(index) == 1 && index[0] == column {
+			return true
+		}
+	}
+	return false
+}
+
+// toServer returns name of the column in shard tables
+func (o *OvsKVImpl) toServer(column string) string {
+	switch column {
+	case PATH_COLUMN:
+		return o.columns.Path
+	case DATA_COLUMN:
+		return o.columns.Data
+	}
+	return column
+}
+
+// fromServer returns OvsKV name of the column of shard tables
+func (o *OvsKVImpl) fromServer(column string) string {
+	switch column {
+	case o.columns.Path:
+		return PATH_COLUMN
+	case o.columns.Data:
+		return DATA_COLUMN
+	}
+	return column
+}
+
+// renamed reports whether columns differ from the default names
+func (o *OvsKVImpl) renamed() bool {
+	return o.columns.Path != PATH_COLUMN || o.columns.Data != DATA_COLUMN
+}
+
+// renameOps returns copies of ops with OvsKV column names replaced by the
+// configured ones
+func (o *OvsKVImpl) renameOps(ops []libovsdb.Operation) []libovsdb.Operation {
+	renamed := make([]libovsdb.Operation, len(ops))
+	for i, op := range ops {
+		if op.Row != nil {
+			op.Row = o.renameRow(op.Row, o.toServer)
+		}
+		if op.Rows != nil {
+			rows := make([]map[string]interface{}, len(op.Rows))
+			for j, row := range op.Rows {
+				rows[j] = o.renameRow(row, o.toServer)
+			}
+			op.Rows = rows
+		}
+		if op.Columns != nil {
+			columns := make([]string, len(op.Columns))
+			for j, c := range op.Columns {
+				columns[j] = o.toServer(c)
+			}
+			op.Columns = columns
+		}
+		op.Where = o.renameConditions(op.Where)
+		op.Mutations = o.renameConditions(op.Mutations)
+		renamed[i] = op
+	}
+	return renamed
+}
+
+// renameConditions renames column of conditions and mutations, both are
+// [column, function, value]
+func (o *OvsKVImpl) renameConditions(conditions []interface{}) []interface{} {
+	if conditions == nil {
+		return nil
+	}
+	renamed := make([]interface{}, len(conditions))
+	for i, c := range conditions {
+		if cond, ok := c.([]interface{}); ok && len(cond) == 3 {
+			if column, ok := cond[0].(string); ok {
+				c = []interface{}{o.toServer(column), cond[1], cond[2]}
+			}
+		}
+		renamed[i] = c
+	}
+	return renamed
+}
+
+func (o *OvsKVImpl) renameRow(row map[string]interface{}, rename func(string) string) map[string]interface{} {
+	renamed := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		renamed[rename(k)] = v
+	}
+	return renamed
+}
+
+// renameReply renames columns of selected rows back to OvsKV names
+func (o *OvsKVImpl) renameReply(reply []libovsdb.OperationResult) {
+	for i := range reply {
+		for j, row := range reply[i].Rows {
+			reply[i].Rows[j] = o.renameRow(row, o.fromServer)
+		}
+	}
+}
+
+// SetKVMWithColumns sets multi-key value along with values of extra
+// columns set by SetColumns. Extra columns not given keep their value on
+// update and get default value on insert.
+func (o *OvsKVImpl) SetKVMWithColumns(key string, val map[string]string, columns map[string]interface{}) (string, error) {
+	kvRow, err := newKVRow(key, val)
+	if err != nil {
+		return "", err
+	}
+	for name, v := range columns {
+		typ, ok := o.columns.Extra[name]
+		if !ok {
+			return "", fmt.Errorf("%w: column %s is not an extra column\n", ErrSchema, name)
+		}
+		if !checkAtom(typ, v) {
+			return "", fmt.Errorf("%w: value %v of column %s is not %s\n", ErrSchema, v, name, typ)
+		}
+		kvRow[name] = v
+	}
+	return o.setKVRow(context.Background(), key, kvRow)
+}
+
+// extraColumns returns names of the extra columns
+func (o *OvsKVImpl) extraColumns() []string {
+	var names []string
+	for name := range o.columns.Extra {
+		names = append(names, name)
+	}
+	return names
+}
+
+// checkAtom returns whether Go value v is a valid atom of OVSDB type typ
+func checkAtom(typ string, v interface{}) bool {
+	switch v.(type) {
+	case string:
+		return typ == "string"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		return typ == "integer"
+	case float32, float64:
+		return typ == "real"
+	case bool:
+		return typ == "boolean"
+	case libovsdb.UUID:
+		return typ == "uuid"
+	}
+	return false
+}
+
+// memSchema describes in-process database, it has any table ovskv asks for
+func memSchema(db_name string, tables []string) *libovsdb.DatabaseSchema {
+	schema := &libovsdb.DatabaseSchema{Name: db_name, Version: SCHEMA_VERSION, Tables: make(map[string]libovsdb.TableSchema)}
+	for _, table := range tables {
+		if !memIndexed(table) {
+			schema.Tables[table] = libovsdb.TableSchema{Columns: map[string]libovsdb.ColumnSchema{
+				"ttl":     {Type: "integer"},
+				"expires": {Type: "integer"},
+			}}
+			continue
+		}
+		schema.Tables[table] = libovsdb.TableSchema{
+			Columns: map[string]libovsdb.ColumnSchema{
+				PATH_COLUMN: {Type: map[string]interface{}{"key": "string", "min": float64(1), "max": "unlimited"}},
+				DATA_COLUMN: {Type: map[string]interface{}{"key": "string", "value": "string", "min": float64(1), "max": "unlimited"}},
+				"revision":  {Type: "integer"},
+				"expires":   {Type: "integer"},
+				"lease":     {Type: "string"},
+			},
+			Indexes: [][]string{{PATH_COLUMN}},
+		}
+	}
+	return schema
+}
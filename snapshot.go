@@ -0,0 +1,273 @@
+package ovskv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ebay/libovsdb"
+)
+
+const (
+	SNAPSHOT_FORMAT  string = "ovskv-snapshot"
+	SNAPSHOT_VERSION int    = 1
+)
+
+// RestoreOptions controls Restore
+type RestoreOptions struct {
+	From string // restore only keys under From, "" for all
+	To   string // put keys under To instead of From
+}
+
+// snapshotFile is what Snapshot writes, a single JSON document
+type snapshotFile struct {
+	Format    string          `json:"format"`
+	Version   int             `json:"version"`
+	Database  string          `json:"database"`
+	Namespace string          `json:"namespace"`
+	Created   time.Time       `json:"created"`
+	Keys      int             `json:"keys"`
+	Checksum  string          `json:"checksum"` // sha256 of compact Rows
+	Rows      json.RawMessage `json:"rows"`
+}
+
+// snapshotRow is a key of the snapshot
+type snapshotRow struct {
+	Key      string                 `json:"key"`
+	Data     OvsKVMap               `json:"data"`
+	Revision uint32                 `json:"revision"`
+	Columns  map[string]interface{} `json:"columns,omitempty"` // extra columns
+}
+
+func (o *OvsKVImpl) Snapshot(w io.Writer) (int, error) {
+	return o.SnapshotCtx(context.Background(), w)
+}
+
+// SnapshotCtx writes all keys of the namespace, read from all shard tables
+// in one transaction, as versioned and checksummed document Restore takes.
+// Returns number of keys written. TTL and leases of the keys are not part
+// of the snapshot.
+func (o *OvsKVImpl) SnapshotCtx(ctx context.Context, w io.Writer) (int, error) {
+	columns := append([]string{"path", "data", "revision"}, o.extraColumns()...)
+	var ops []libovsdb.Operation
+	for _, table := range o.shardTables() {
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   table,
+			Where:   []interface{}{anyRow},
+			Columns: columns,
+		})
+	}
+	reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops)
+	if err != nil {
+		return 0, err
+	}
+	var rows []libovsdb.ResultRow
+	for i := range ops {
+		rows = append(rows, reply[i].Rows...)
+	}
+
+	keys := make([]snapshotRow, 0, len(rows))
+	for _, row := range rows {
+		key := snapshotRow{Key: pathKey(row["path"]), Data: dataMap(row["data"]), Revision: rowRevision(row)}
+		for _, name := range o.extraColumns() {
+			if key.Columns == nil {
+				key.Columns = make(map[string]interface{})
+			}
+			key.Columns[name] = row[name]
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha256.Sum256(b)
+	err = json.NewEncoder(w).Encode(snapshotFile{
+		Format:    SNAPSHOT_FORMAT,
+		Version:   SNAPSHOT_VERSION,
+		Database:  o.db_name,
+		Namespace: o.db_namespace,
+		Created:   time.Now().UTC(),
+		Keys:      len(keys),
+		Checksum:  hex.EncodeToString(sum[:]),
+		Rows:      b,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// readSnapshot decodes and verifies snapshot
+func readSnapshot(r io.Reader) ([]snapshotRow, error) {
+	var file snapshotFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshot, err)
+	}
+	if file.Format != SNAPSHOT_FORMAT {
+		return nil, fmt.Errorf("%w: format %q", ErrSnapshot, file.Format)
+	}
+	if file.Version > SNAPSHOT_VERSION {
+		return nil, fmt.Errorf("%w: version %d is newer than %d", ErrSnapshot, file.Version, SNAPSHOT_VERSION)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, file.Rows); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshot, err)
+	}
+	sum := sha256.Sum256(compact.Bytes())
+	if hex.EncodeToString(sum[:]) != file.Checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrSnapshot)
+	}
+
+	var rows []snapshotRow
+	if err := json.Unmarshal(compact.Bytes(), &rows); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshot, err)
+	}
+	if len(rows) != file.Keys {
+		return nil, fmt.Errorf("%w: %d keys, header says %d", ErrSnapshot, len(rows), file.Keys)
+	}
+	return rows, nil
+}
+
+func (o *OvsKVImpl) Restore(r io.Reader, opts RestoreOptions) (int, error) {
+	return o.RestoreCtx(context.Background(), r, opts)
+}
+
+// RestoreCtx replaces keys of the namespace by keys of the snapshot in one
+// transaction. With From set only keys under From are replaced by keys of
+// the snapshot under From, with To set keys under To are replaced by them
+// moved from From to To. Keys outside of the scope are kept. Fails with ErrSnapshot if the snapshot is damaged
+// and with ErrConflict if keys to be replaced change meanwhile. Restored
+// keys get revision past both the snapshot and the replaced one, so that
+// revisions read before restore do not match. Returns number of keys
+// restored.
+func (o *OvsKVImpl) RestoreCtx(ctx context.Context, r io.Reader, opts RestoreOptions) (int, error) {
+	rows, err := readSnapshot(r)
+	if err != nil {
+		return 0, err
+	}
+	from := strings.TrimSuffix(opts.From, SEPA)
+	remap := opts.To != ""
+	scope := from
+	if remap {
+		scope = strings.TrimSuffix(opts.To, SEPA)
+	}
+
+	keys := make([]snapshotRow, 0, len(rows))
+	for _, row := range rows {
+		if from != "" && row.Key != from && !strings.HasPrefix(row.Key, from+SEPA) {
+			continue
+		}
+		// keys restored in place are kept as they are, relative ones stay
+		// relative
+		if remap {
+			rel := strings.TrimPrefix(row.Key, from)
+			if rel != "" && !strings.HasPrefix(rel, SEPA) {
+				rel = SEPA + rel
+			}
+			row.Key = scope + rel
+		}
+		keys = append(keys, row)
+	}
+
+	// current rows, to be waited for unchanged and replaced, all of them
+	// for the whole namespace as relative keys do not include the root
+	condition := anyRow
+	if scope != "" {
+		pathSet, err := pathFmt(scope)
+		if err != nil {
+			return 0, fmt.Errorf("path error: %v\n", err)
+		}
+		condition = libovsdb.NewCondition("path", "includes", pathSet)
+	}
+	tables := o.shardTables()
+	var ops []libovsdb.Operation
+	for _, table := range tables {
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   table,
+			Where:   []interface{}{condition},
+			Columns: []string{"_uuid", "path", "revision"},
+		})
+	}
+	reply, err := o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops)
+	if err != nil {
+		return 0, err
+	}
+
+	revisions := make(map[string]uint32)
+	ops = ops[:0]
+	for i, table := range tables {
+		current := reply[i].Rows
+		// a table can not be waited to have no rows, deleting whatever
+		// is there at commit is enough for those
+		if len(current) > 0 {
+			expected := make([]map[string]interface{}, len(current))
+			for j, row := range current {
+				expected[j] = map[string]interface{}{"_uuid": row["_uuid"], "revision": row["revision"]}
+				revisions[pathKey(row["path"])] = rowRevision(row)
+			}
+			ops = append(ops, libovsdb.Operation{
+				Op:      OP_WAIT,
+				Table:   table,
+				Timeout: WAIT_TIMEOUT,
+				Where:   []interface{}{condition},
+				Columns: []string{"_uuid", "revision"},
+				Until:   "==",
+				Rows:    expected,
+			})
+		}
+		ops = append(ops, libovsdb.Operation{
+			Op:    OP_DELETE,
+			Table: table,
+			Where: []interface{}{condition},
+		})
+	}
+	opKeys := make([]string, len(ops), len(ops)+len(keys))
+	for i := range ops {
+		opKeys[i] = scope
+	}
+
+	for _, key := range keys {
+		kvRow, err := newKVRow(key.Key, key.Data)
+		if err != nil {
+			return 0, err
+		}
+		for name, v := range key.Columns {
+			if _, ok := o.columns.Extra[name]; ok && v != nil {
+				kvRow[name] = v
+			}
+		}
+		revision := key.Revision
+		if revisions[key.Key] > revision {
+			revision = revisions[key.Key]
+		}
+		kvRow["revision"] = revision + 1
+		ops = append(ops, libovsdb.Operation{
+			Op:    OP_INSERT,
+			Table: o.shardTable(key.Key),
+			Row:   kvRow,
+		})
+		opKeys = append(opKeys, key.Key)
+	}
+
+	reply, err = o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, opKeys...)
+	if err != nil {
+		return 0, conflictError(err, map[string]bool{scope: true})
+	}
+	return len(keys), nil
+}
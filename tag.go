@@ -0,0 +1,98 @@
+package ovskv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldTag is parsed ovskv tag of a struct field, key name followed by
+// comma separated options as in encoding/json:
+//
+//	`ovskv:"name"`              key name, fields without it are skipped
+//	`ovskv:"name,omitempty"`    zero value is stored as absent key
+//	`ovskv:"prio,default=100"`  value loaded if the key is absent
+//	`ovskv:"status,readonly"`   loaded, but not written by Save
+//	`ovskv:",inline"`           fields of the struct are keys of the parent
+type fieldTag struct {
+	name       string
+	omitEmpty  bool
+	readOnly   bool
+	inline     bool
+	hasDefault bool
+	def        string
+}
+
+func parseTag(tag string) (fieldTag, error) {
+	parts := strings.Split(tag, ",")
+	t := fieldTag{name: normalizeTag(parts[0])}
+	for _, opt := range parts[1:] {
+		switch {
+		case opt == "omitempty":
+			t.omitEmpty = true
+		case opt == "readonly":
+			t.readOnly = true
+		case opt == "inline":
+			t.inline = true
+		case strings.HasPrefix(opt, "default="):
+			t.hasDefault = true
+			t.def = strings.TrimPrefix(opt, "default=")
+		default:
+			return t, fmt.Errorf("unknown ovskv tag option %q", opt)
+		}
+	}
+	return t, nil
+}
+
+// structField is a mapped field of a struct
+type structField struct {
+	value reflect.Value
+	tag   fieldTag
+}
+
+// structFields returns mapped fields of struct v, fields of inline
+// structs are returned in place of the struct
+func structFields(v reflect.Value) ([]structField, error) {
+	var fields []structField
+	for i := 0; i < v.NumField(); i++ {
+		fieldType := v.Type().Field(i)
+		tag, err := parseTag(fieldType.Tag.Get(OVSKV_TAG))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v\n", v.Type().Name(), fieldType.Name, err)
+		}
+		if tag.inline {
+			if v.Field(i).Kind() != reflect.Struct {
+				return nil, fmt.Errorf("%s.%s: inline field is not a struct\n", v.Type().Name(), fieldType.Name)
+			}
+			inline, err := structFields(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, inline...)
+			continue
+		}
+		if len(tag.name) == 0 {
+			continue
+		}
+		fields = append(fields, structField{value: v.Field(i), tag: tag})
+	}
+	return fields, nil
+}
+
+// absent sets field whose key does not exist to its default, or to zero
+// value if the key is optional, otherwise the key is missing
+func (f structField) absent(path string, lerr *LoadError) {
+	switch {
+	case f.tag.hasDefault:
+		value, err := parseValue(f.value.Type(), f.tag.def)
+		if err != nil {
+			lerr.invalid(path, fmt.Errorf("default: %v", err))
+			return
+		}
+		f.value.Set(value)
+	case f.tag.omitEmpty || optional(f.value):
+		f.value.Set(reflect.Zero(f.value.Type()))
+	default:
+		lerr.missing(path)
+	}
+}
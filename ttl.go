@@ -0,0 +1,295 @@
+package ovskv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebay/libovsdb"
+)
+
+const (
+	// LEASE_TABLE is appended to namespace to name the table of leases
+	LEASE_TABLE string = "Lease"
+	// REAP_BATCH is the max number of expired keys deleted in one
+	// transaction
+	REAP_BATCH int = 100
+)
+
+// Lease is a TTL shared by keys attached to it, the keys are deleted when
+// the lease is revoked or expires without being kept alive
+type Lease struct {
+	ID  string
+	TTL time.Duration
+}
+
+func (o *OvsKVImpl) leaseTable() string {
+	return o.db_namespace + LEASE_TABLE
+}
+
+// expiresAt returns expiry time as stored in expires column, ms since epoch
+func expiresAt(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+}
+
+func uuidCondition(uuid string) []interface{} {
+	return libovsdb.NewCondition("_uuid", "==", libovsdb.UUID{GoUUID: uuid})
+}
+
+// SetKVMWithTTL sets multi-key value which is deleted by reaper once ttl
+// passes, unless the key is set again meanwhile
+func (o *OvsKVImpl) SetKVMWithTTL(key string, val map[string]string, ttl time.Duration) (string, error) {
+	kvRow, err := newKVRow(key, val)
+	if err != nil {
+		return "", err
+	}
+	kvRow["expires"] = expiresAt(ttl)
+	return o.setKVRow(context.Background(), key, kvRow)
+}
+
+// SetKVWithTTL sets value which is deleted by reaper once ttl passes
+func (o *OvsKVImpl) SetKVWithTTL(key, val string, ttl time.Duration) (string, error) {
+	return o.SetKVMWithTTL(key, o.V(val), ttl)
+}
+
+// SetKVMWithLease sets multi-key value which lives as long as the lease,
+// fails with ErrNotFound if there is no such lease
+func (o *OvsKVImpl) SetKVMWithLease(key string, val map[string]string, id string) (string, error) {
+	kvRow, err := newKVRow(key, val)
+	if err != nil {
+		return "", err
+	}
+	kvRow["lease"] = id
+
+	// lease has to exist when the key is written
+	guard := libovsdb.Operation{
+		Op:      OP_WAIT,
+		Table:   o.leaseTable(),
+		Timeout: WAIT_TIMEOUT,
+		Where:   []interface{}{uuidCondition(id)},
+		Columns: []string{"_uuid"},
+		Until:   "==",
+		Rows:    []map[string]interface{}{{"_uuid": libovsdb.UUID{GoUUID: id}}},
+	}
+	uuid, err := o.setKVRow(context.Background(), key, kvRow, guard)
+	var terr *TransactError
+	if errors.As(err, &terr) && terr.Index == 0 && terr.Op == OP_WAIT {
+		return "", fmt.Errorf("%w: lease %s", ErrNotFound, id)
+	}
+	return uuid, err
+}
+
+// SetKVWithLease sets value which lives as long as the lease
+func (o *OvsKVImpl) SetKVWithLease(key, val string, id string) (string, error) {
+	return o.SetKVMWithLease(key, o.V(val), id)
+}
+
+// Grant creates a lease which expires after ttl unless kept alive
+func (o *OvsKVImpl) Grant(ttl time.Duration) (*Lease, error) {
+	op := libovsdb.Operation{
+		Op:    OP_INSERT,
+		Table: o.leaseTable(),
+		Row: map[string]interface{}{
+			"ttl":     int64(ttl / time.Millisecond),
+			"expires": expiresAt(ttl),
+		},
+	}
+	reply, err := o.transact(context.Background(), op)
+	err = isTransactError(reply, err, []libovsdb.Operation{op})
+	if err != nil {
+		return nil, err
+	}
+	return &Lease{ID: reply[0].UUID.GoUUID, TTL: ttl}, nil
+}
+
+// KeepAlive extends the lease by its ttl from now
+func (o *OvsKVImpl) KeepAlive(id string) error {
+	op := libovsdb.Operation{
+		Op:      OP_SELECT,
+		Table:   o.leaseTable(),
+		Where:   []interface{}{uuidCondition(id)},
+		Columns: []string{"ttl"},
+	}
+	reply, err := o.transact(context.Background(), op)
+	err = isTransactError(reply, err, []libovsdb.Operation{op})
+	if err != nil {
+		return err
+	}
+	if len(reply[0].Rows) == 0 {
+		return fmt.Errorf("%w: lease %s", ErrNotFound, id)
+	}
+	ttl, _ := reply[0].Rows[0]["ttl"].(float64)
+
+	op = libovsdb.Operation{
+		Op:    OP_UPDATE,
+		Table: o.leaseTable(),
+		Where: []interface{}{uuidCondition(id)},
+		Row:   map[string]interface{}{"expires": expiresAt(time.Duration(ttl) * time.Millisecond)},
+	}
+	reply, err = o.transact(context.Background(), op)
+	err = isTransactError(reply, err, []libovsdb.Operation{op})
+	if err != nil {
+		return err
+	}
+	if reply[0].Count == 0 {
+		return fmt.Errorf("%w: lease %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// Revoke deletes the lease and all keys attached to it in one transaction
+func (o *OvsKVImpl) Revoke(id string) error {
+	ops := o.leaseDelete(id)
+	reply, err := o.transact(context.Background(), ops...)
+	err = isTransactError(reply, err, ops)
+	if err != nil {
+		return err
+	}
+	if reply[len(ops)-1].Count == 0 {
+		return fmt.Errorf("%w: lease %s", ErrNotFound, id)
+	}
+	return nil
+}
+
+// leaseDelete returns operations deleting keys of the lease from all
+// shards, followed by the lease itself
+func (o *OvsKVImpl) leaseDelete(id string) []libovsdb.Operation {
+	var ops []libovsdb.Operation
+	for _, table := range o.shardTables() {
+		ops = append(ops, libovsdb.Operation{
+			Op:    OP_DELETE,
+			Table: table,
+			Where: []interface{}{libovsdb.NewCondition("lease", "==", id)},
+		})
+	}
+	return append(ops, libovsdb.Operation{
+		Op:    OP_DELETE,
+		Table: o.leaseTable(),
+		Where: []interface{}{uuidCondition(id)},
+	})
+}
+
+// Reap deletes keys which TTL has passed and leases which expired along
+// with their keys. Returns number of deleted keys.
+func (o *OvsKVImpl) Reap() (int, error) {
+	return o.ReapCtx(context.Background())
+}
+
+// ReapCtx works as Reap, giving up when ctx is done
+func (o *OvsKVImpl) ReapCtx(ctx context.Context) (int, error) {
+	now := expiresAt(0)
+	expired := []interface{}{
+		libovsdb.NewCondition("expires", ">", 0),
+		libovsdb.NewCondition("expires", "<", now),
+	}
+
+	var selects []libovsdb.Operation
+	for _, table := range append(o.shardTables(), o.leaseTable()) {
+		selects = append(selects, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   table,
+			Where:   expired,
+			Columns: []string{"_uuid"},
+		})
+	}
+	reply, err := o.transact(ctx, selects...)
+	err = isTransactError(reply, err, selects)
+	if err != nil {
+		return 0, err
+	}
+
+	// expired keys, batched, rows refreshed meanwhile stay as expiry is
+	// checked again
+	count := 0
+	var ops []libovsdb.Operation
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		reply, err := o.transact(ctx, ops...)
+		err = isTransactError(reply, err, ops)
+		if err != nil {
+			return err
+		}
+		for i := range ops {
+			count += reply[i].Count
+		}
+		ops = ops[:0]
+		return nil
+	}
+	for i, table := range o.shardTables() {
+		for _, row := range reply[i].Rows {
+			uuid := row["_uuid"].(libovsdb.UUID).GoUUID
+			ops = append(ops, libovsdb.Operation{
+				Op:    OP_DELETE,
+				Table: table,
+				Where: append([]interface{}{uuidCondition(uuid)}, expired...),
+			})
+			if len(ops) == REAP_BATCH {
+				if err := flush(); err != nil {
+					return count, err
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+
+	// expired leases, each in own transaction which fails if the lease
+	// was kept alive meanwhile
+	for _, row := range reply[len(reply)-1].Rows {
+		id := row["_uuid"].(libovsdb.UUID).GoUUID
+		guard := libovsdb.Operation{
+			Op:      OP_WAIT,
+			Table:   o.leaseTable(),
+			Timeout: WAIT_TIMEOUT,
+			Where:   append([]interface{}{uuidCondition(id)}, expired...),
+			Columns: []string{"_uuid"},
+			Until:   "==",
+			Rows:    []map[string]interface{}{{"_uuid": libovsdb.UUID{GoUUID: id}}},
+		}
+		ops := append([]libovsdb.Operation{guard}, o.leaseDelete(id)...)
+		reply, err := o.transact(ctx, ops...)
+		err = isTransactError(reply, err, ops)
+		var terr *TransactError
+		if errors.As(err, &terr) && terr.Index == 0 {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		for i := 1; i < len(ops)-1; i++ {
+			count += reply[i].Count
+		}
+	}
+	return count, nil
+}
+
+// StartReaper runs Reap every interval until Disconnect
+func (o *OvsKVImpl) StartReaper(interval time.Duration) {
+	o.mu.Lock()
+	if o.reaping {
+		o.mu.Unlock()
+		return
+	}
+	o.reaping = true
+	o.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			o.mu.RLock()
+			closed := o.closed
+			o.mu.RUnlock()
+			if closed {
+				return
+			}
+			if o.Healthy() {
+				o.Reap()
+			}
+		}
+	}()
+}
@@ -0,0 +1,315 @@
+package ovskv
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/ebay/libovsdb"
+)
+
+// Txn accumulates key operations and commits them in one OVSDB transaction,
+// so either all of them are applied or none.
+type Txn struct {
+	o   *OvsKVImpl
+	ops []txnOp
+	err error
+}
+
+// txnOp is a pending key operation, it is turned into libovsdb.Operation
+// on Commit
+type txnOp struct {
+	op      string // OP_INSERT, OP_UPDATE (insert or update), OP_DELETE or OP_WAIT
+	cond    string // condition function of OP_DELETE
+	key     string
+	row     OvsKVRow
+	checked bool               // apply only if key is at version
+	version uint32             // zero means key must not exist
+	wait    libovsdb.Operation // OP_WAIT to send as is
+	cause   error              // error OP_WAIT failure means
+}
+
+// Begin starts a new transaction. Nothing is sent to ovsdb until Commit.
+func (o *OvsKVImpl) Begin() *Txn {
+	return &Txn{o: o}
+}
+
+// SetM adds or updates key with multi-key value
+func (t *Txn) SetM(key string, val map[string]string) *Txn {
+	return t.add(OP_UPDATE, key, val)
+}
+
+// Set adds or updates key with value
+func (t *Txn) Set(key, val string) *Txn {
+	return t.SetM(key, t.o.V(val))
+}
+
+// InsertM adds key with multi-key value, Commit fails if key exists
+func (t *Txn) InsertM(key string, val map[string]string) *Txn {
+	return t.add(OP_INSERT, key, val)
+}
+
+// Insert adds key with value, Commit fails if key exists
+func (t *Txn) Insert(key, val string) *Txn {
+	return t.InsertM(key, t.o.V(val))
+}
+
+// Delete removes keys matching op, same as DeleteKV
+func (t *Txn) Delete(op, key string) *Txn {
+	if t.err == nil {
+		t.ops = append(t.ops, txnOp{op: OP_DELETE, cond: op, key: key})
+	}
+	return t
+}
+
+// Save stores the whole structure given to Init as part of the transaction
+func (t *Txn) Save() error {
+	return t.o.saveField(context.Background(), t, t.o.data, "")
+}
+
+// SaveField stores a specific field as part of the transaction
+func (t *Txn) SaveField(field interface{}) error {
+	path, _, err := t.o.getInfo(field)
+	if err != nil {
+		return err
+	}
+
+	return t.o.saveField(context.Background(), t, reflect.ValueOf(field), path)
+}
+
+func (t *Txn) setKVM(_ context.Context, key string, val map[string]string) error {
+	t.SetM(key, val)
+	return t.err
+}
+
+func (t *Txn) deleteKV(_ context.Context, key string) error {
+	t.Delete("includes", key)
+	return t.err
+}
+
+// SetMIfVersion adds or updates key with multi-key value if its revision is
+// still version, otherwise Commit fails with ErrConflict. Zero version
+// requires the key not to exist.
+func (t *Txn) SetMIfVersion(key string, val map[string]string, version uint32) *Txn {
+	t.add(OP_UPDATE, key, val)
+	if t.err == nil {
+		t.ops[len(t.ops)-1].checked = true
+		t.ops[len(t.ops)-1].version = version
+	}
+	return t
+}
+
+// SetIfVersion adds or updates key with value if its revision is still
+// version, otherwise Commit fails with ErrConflict
+func (t *Txn) SetIfVersion(key, val string, version uint32) *Txn {
+	return t.SetMIfVersion(key, t.o.V(val), version)
+}
+
+// DeleteIfVersion removes key if its revision is still version,
+// otherwise Commit fails with ErrConflict. Zero version requires the key
+// not to exist, same as for SetMIfVersion.
+func (t *Txn) DeleteIfVersion(key string, version uint32) *Txn {
+	if t.err == nil {
+		t.ops = append(t.ops, txnOp{op: OP_DELETE, cond: "==", key: key, checked: true, version: version})
+	}
+	return t
+}
+
+// guard adds wait operation made for key, failure of which fails Commit
+// with error matching cause
+func (t *Txn) guard(key string, wait libovsdb.Operation, cause error) *Txn {
+	if t.err == nil {
+		t.ops = append(t.ops, txnOp{op: OP_WAIT, key: key, wait: wait, cause: cause})
+	}
+	return t
+}
+
+func (t *Txn) add(op, key string, val map[string]string) *Txn {
+	if t.err != nil {
+		return t
+	}
+	row, err := newKVRow(key, val)
+	if err != nil {
+		t.err = err
+		return t
+	}
+	t.ops = append(t.ops, txnOp{op: op, key: key, row: row})
+	return t
+}
+
+// exists looks up in one request which of the keys to be set already exist
+func (t *Txn) exists(ctx context.Context) (map[string]bool, error) {
+	var keys []string
+	var ops []libovsdb.Operation
+	found := make(map[string]bool)
+	for _, op := range t.ops {
+		if op.op != OP_UPDATE || op.checked {
+			continue
+		}
+		if _, ok := found[op.key]; ok {
+			continue
+		}
+		found[op.key] = false
+
+		pathSet, err := pathFmt(op.key)
+		if err != nil {
+			return nil, err
+		}
+		condition := libovsdb.NewCondition("path", "==", pathSet)
+		ops = append(ops, libovsdb.Operation{
+			Op:      OP_SELECT,
+			Table:   t.o.shardTable(op.key),
+			Where:   []interface{}{condition},
+			Columns: []string{"_uuid"},
+		})
+		keys = append(keys, op.key)
+	}
+	if len(ops) == 0 {
+		return found, nil
+	}
+
+	reply, err := t.o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		found[key] = len(reply[i].Rows) > 0
+	}
+	return found, nil
+}
+
+// Commit sends all accumulated operations in one transaction.
+// Set is resolved into update or insert depending on whether key exists
+// at Commit time, taking earlier operations of the transaction into account.
+// Commit fails with ErrConflict if a key resolved to be updated is deleted
+// by another writer before the transaction is applied.
+func (t *Txn) Commit() error {
+	return t.CommitCtx(context.Background())
+}
+
+// CommitCtx works as Commit, giving up when ctx is done. The transaction
+// may still be applied if ctx is done after it was sent.
+func (t *Txn) CommitCtx(ctx context.Context) error {
+	if t.err != nil {
+		return t.err
+	}
+	if len(t.ops) == 0 {
+		return nil
+	}
+
+	found, err := t.exists(ctx)
+	if err != nil {
+		return err
+	}
+	// keys found to exist, updates of which have to wait for them to be
+	// still there
+	unguarded := make(map[string]bool)
+	for key, exists := range found {
+		unguarded[key] = exists
+	}
+
+	ops := make([]libovsdb.Operation, 0, len(t.ops))
+	keys := make([]string, 0, len(t.ops))
+	checked := make(map[string]bool)
+	causes := make(map[int]error)
+	for _, op := range t.ops {
+		pathSet, err := pathFmt(op.key)
+		if err != nil {
+			return err
+		}
+		table := t.o.shardTable(op.key)
+		condition := libovsdb.NewCondition("path", "==", pathSet)
+
+		if op.checked {
+			checked[op.key] = true
+			if op.version > 0 {
+				ops = append(ops, revisionWait(table, condition, op.version))
+				keys = append(keys, op.key)
+			} else if op.op == OP_DELETE {
+				// insert of SetMIfVersion fails by itself
+				ops = append(ops, pathWaitOp(table, pathSet, "!="))
+				keys = append(keys, op.key)
+			}
+		}
+
+		switch op.op {
+		case OP_WAIT:
+			causes[len(ops)] = op.cause
+			ops = append(ops, op.wait)
+
+		case OP_DELETE:
+			for key := range found {
+				if pathMatch(op.cond, key, op.key) {
+					found[key] = false
+					unguarded[key] = false
+				}
+			}
+			for _, table := range t.o.queryTables(op.cond, op.key) {
+				ops = append(ops, libovsdb.Operation{
+					Op:    OP_DELETE,
+					Table: table,
+					Where: []interface{}{libovsdb.NewCondition("path", op.cond, pathSet)},
+				})
+				keys = append(keys, op.key)
+			}
+			continue
+
+		case OP_INSERT:
+			found[op.key] = true
+			ops = append(ops, insertOp(table, op.row))
+
+		case OP_UPDATE:
+			exists := found[op.key]
+			if op.checked {
+				exists = op.version > 0
+			}
+			if exists {
+				if unguarded[op.key] {
+					unguarded[op.key] = false
+					checked[op.key] = true
+					ops = append(ops, pathWaitOp(table, pathSet, "=="))
+					keys = append(keys, op.key)
+				}
+				ops = append(ops, libovsdb.Operation{
+					Op:    OP_UPDATE,
+					Table: table,
+					Where: []interface{}{condition},
+					Row:   op.row,
+				})
+				ops = append(ops, revisionMutate(table, condition))
+				keys = append(keys, op.key)
+			} else {
+				found[op.key] = true
+				ops = append(ops, insertOp(table, op.row))
+			}
+		}
+		keys = append(keys, op.key)
+	}
+
+	reply, err := t.o.transact(ctx, ops...)
+	err = isTransactError(reply, err, ops, keys...)
+	if err != nil {
+		var terr *TransactError
+		if errors.As(err, &terr) && causes[terr.Index] != nil {
+			terr.cause = causes[terr.Index]
+			return err
+		}
+		return conflictError(err, checked)
+	}
+	return nil
+}
+
+// insertOp inserts row with initial revision
+func insertOp(table string, row OvsKVRow) libovsdb.Operation {
+	insertRow := make(OvsKVRow, len(row)+1)
+	for k, v := range row {
+		insertRow[k] = v
+	}
+	insertRow["revision"] = 1
+	return libovsdb.Operation{
+		Op:    OP_INSERT,
+		Table: table,
+		Row:   insertRow,
+	}
+}
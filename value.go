@@ -0,0 +1,221 @@
+package ovskv
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Values are stored as strings under "v" of the data map, the same
+// encoding is used for elements of maps and slices:
+//
+//	string, bool, ints, uints  strconv formatting
+//	float32, float64           shortest 'g' formatting which parses back
+//	time.Time                  RFC3339Nano
+//	time.Duration              time.Duration.String, e.g. "1m30s"
+//	[]byte                     standard base64
+//	net.IP, net.IPNet          "10.0.0.1", "10.0.0.1/24"
+//
+// Other types implementing encoding.TextMarshaler are stored as their
+// text. Types implementing OvsKVMarshaler are stored as the data map
+// they return, always as a key of their own.
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	ipType       = reflect.TypeOf(net.IP{})
+	ipNetType    = reflect.TypeOf(net.IPNet{})
+
+	marshalerType       = reflect.TypeOf((*OvsKVMarshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*OvsKVUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// OvsKVMarshaler is implemented by types which store themselves as data
+// map of the key
+type OvsKVMarshaler interface {
+	MarshalOvsKV() (map[string]string, error)
+}
+
+// OvsKVUnmarshaler is implemented by types which load themselves from
+// data map of the key
+type OvsKVUnmarshaler interface {
+	UnmarshalOvsKV(data map[string]string) error
+}
+
+// implements returns whether t or pointer to t implements iface
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || (t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(iface))
+}
+
+// as returns v as iface, taking address of v or of its copy if only
+// pointer to v implements it
+func as(v reflect.Value, iface reflect.Type) interface{} {
+	if v.Type().Implements(iface) {
+		return v.Interface()
+	}
+	if !v.CanAddr() {
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		v = p.Elem()
+	}
+	return v.Addr().Interface()
+}
+
+// isValue returns whether t is stored as a single value rather than
+// a subtree of keys
+func isValue(t reflect.Type) bool {
+	switch t {
+	case timeType, ipNetType:
+		return true
+	}
+	if t.Kind() != reflect.Ptr {
+		if implements(t, marshalerType) || implements(t, unmarshalerType) {
+			return false
+		}
+		if implements(t, textMarshalerType) || implements(t, textUnmarshalerType) {
+			return true
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Ptr:
+		return false
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return true
+}
+
+// formatValue converts value into string to be stored
+func formatValue(v reflect.Value) (string, error) {
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	case durationType:
+		return time.Duration(v.Int()).String(), nil
+	case ipType:
+		return net.IP(v.Bytes()).String(), nil
+	case ipNetType:
+		ipNet := v.Interface().(net.IPNet)
+		return ipNet.String(), nil
+	}
+	if implements(v.Type(), textMarshalerType) {
+		text, err := as(v, textMarshalerType).(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes()), nil
+		}
+	}
+	return "", fmt.Errorf("not supported field kind: %v", v.Kind())
+}
+
+// parseValue converts stored string into value of type t
+func parseValue(t reflect.Type, s string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	switch t {
+	case timeType:
+		value, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return v, err
+		}
+		v.Set(reflect.ValueOf(value))
+		return v, nil
+
+	case durationType:
+		value, err := time.ParseDuration(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(int64(value))
+		return v, nil
+
+	case ipType:
+		value := net.ParseIP(s)
+		if value == nil {
+			return v, fmt.Errorf("invalid IP address %q", s)
+		}
+		v.SetBytes(value)
+		return v, nil
+
+	case ipNetType:
+		ip, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return v, err
+		}
+		// keep host bits of the address, as String does
+		if ip4 := ip.To4(); ip4 != nil && len(ipNet.IP) == net.IPv4len {
+			ip = ip4
+		}
+		v.Set(reflect.ValueOf(net.IPNet{IP: ip, Mask: ipNet.Mask}))
+		return v, nil
+	}
+	if implements(t, textUnmarshalerType) {
+		err := as(v, textUnmarshalerType).(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return v, err
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(value)
+
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(value)
+
+	case reflect.Bool:
+		if s == "true" {
+			v.SetBool(true)
+		} else if s != "false" {
+			return v, fmt.Errorf("invalid bool value %q", s)
+		}
+
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.Uint8 {
+			return v, fmt.Errorf("not supported field kind: %v", t.Kind())
+		}
+		value, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetBytes(value)
+
+	default:
+		return v, fmt.Errorf("not supported field kind: %v", t.Kind())
+	}
+	return v, nil
+}
@@ -0,0 +1,408 @@
+package ovskv
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ebay/libovsdb"
+)
+
+type WatchEventType int
+
+const (
+	// key was added or modified
+	WatchPut WatchEventType = iota
+	// key was deleted
+	WatchDelete
+	// monitor was re-issued after reconnect, events missed while
+	// disconnected follow as Put and Delete
+	WatchResync
+)
+
+func (t WatchEventType) String() string {
+	switch t {
+	case WatchPut:
+		return "put"
+	case WatchDelete:
+		return "delete"
+	case WatchResync:
+		return "resync"
+	}
+	return fmt.Sprintf("WatchEventType(%d)", int(t))
+}
+
+// WatchEvent describes change of a key. Values are data maps of the key,
+// single value keys keep their value under "v".
+type WatchEvent struct {
+	Type     WatchEventType
+	Key      string
+	UUID     string
+	Revision uint32   // revision of the key after Put
+	OldValue OvsKVMap // nil on insert
+	NewValue OvsKVMap // nil on delete
+}
+
+// Watcher delivers events of keys under the watched prefix
+type Watcher struct {
+	prefix string
+	hub    *watchHub
+	events chan WatchEvent
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	queue  []WatchEvent
+}
+
+// watchRow is the last known state of a monitored row
+type watchRow struct {
+	key    string
+	data   OvsKVMap
+	fields map[string]interface{}
+}
+
+// watchHub keeps the single monitor of shard tables and fans its
+// updates out to all watchers of OvsKVImpl
+type watchHub struct {
+	o        *OvsKVImpl
+	mu       sync.Mutex
+	rows     map[string]watchRow
+	keys     map[string]string // key to uuid
+	watchers map[*Watcher]bool
+	syncing  bool
+	synced   bool
+	live     bool // rows are kept current
+	pending  []libovsdb.TableUpdates
+}
+
+// Watch starts watching keys which include prefix, the same keys
+// GetKV("includes", prefix) would return. Events are delivered in order
+// until Close is called or OvsKVImpl disconnected.
+func (o *OvsKVImpl) Watch(prefix string) (*Watcher, error) {
+	if o.schemaErr != nil {
+		return nil, o.schemaErr
+	}
+	hub, err := o.watchHub()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		prefix: prefix,
+		hub:    hub,
+		events: make(chan WatchEvent),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	hub.mu.Lock()
+	hub.watchers[w] = true
+	hub.mu.Unlock()
+
+	go w.run()
+	return w, nil
+}
+
+// Events returns the channel of events, it is closed after Close
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() {
+	w.hub.mu.Lock()
+	delete(w.hub.watchers, w)
+	w.hub.mu.Unlock()
+	w.once.Do(func() { close(w.done) })
+}
+
+// push queues events without blocking the notification handler
+func (w *Watcher) push(events ...WatchEvent) {
+	w.mu.Lock()
+	w.queue = append(w.queue, events...)
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) run() {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.notify:
+		case <-w.done:
+			return
+		}
+
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+
+		for _, ev := range queue {
+			select {
+			case w.events <- ev:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *Watcher) match(key string) bool {
+	return pathMatch("includes", key, w.prefix)
+}
+
+func (o *OvsKVImpl) watchHub() (*watchHub, error) {
+	o.mu.Lock()
+	if o.watch != nil {
+		o.mu.Unlock()
+		return o.watch, nil
+	}
+	hub := &watchHub{
+		o:        o,
+		rows:     make(map[string]watchRow),
+		keys:     make(map[string]string),
+		watchers: make(map[*Watcher]bool),
+	}
+	o.watch = hub
+	o.mu.Unlock()
+
+	if err := hub.monitor(o.client()); err != nil {
+		o.mu.Lock()
+		o.watch = nil
+		o.mu.Unlock()
+		return nil, err
+	}
+	return hub, nil
+}
+
+func (h *watchHub) context() string {
+	return "ovskv:" + h.o.db_namespace
+}
+
+// monitor registers the hub on the client and issues monitor of the shard
+// tables. Updates arriving before the initial rows are applied get queued.
+func (h *watchHub) monitor(c ovsClient) error {
+	h.mu.Lock()
+	h.syncing = true
+	h.pending = nil
+	h.mu.Unlock()
+
+	c.Register(h)
+	requests := make(map[string]libovsdb.MonitorRequest)
+	columns := []string{h.o.toServer("path"), h.o.toServer("data"), "revision"}
+	columns = append(columns, h.o.extraColumns()...)
+	for _, table := range h.o.shardTables() {
+		requests[table] = libovsdb.MonitorRequest{
+			Columns: columns,
+			Select: libovsdb.MonitorSelect{
+				Initial: true,
+				Insert:  true,
+				Delete:  true,
+				Modify:  true,
+			},
+		}
+	}
+	initial, err := c.Monitor(h.o.db_name, h.context(), requests)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.syncing = false
+	if err != nil {
+		c.Unregister(h)
+		return err
+	}
+
+	// anything which differs from rows known before reconnect
+	// is reported after resync
+	resync := h.synced
+	h.synced = true
+	h.live = true
+	old := h.rows
+	h.rows = make(map[string]watchRow)
+	h.keys = make(map[string]string)
+	h.apply(*initial, false)
+	if resync {
+		h.resync(old)
+	}
+	for _, updates := range h.pending {
+		h.apply(updates, true)
+	}
+	h.pending = nil
+	return nil
+}
+
+func (h *watchHub) resync(old map[string]watchRow) {
+	events := []WatchEvent{{Type: WatchResync}}
+	for uuid, row := range h.rows {
+		prev, ok := old[uuid]
+		if !ok {
+			events = append(events, WatchEvent{Type: WatchPut, Key: row.key, UUID: uuid, Revision: row.revision(), NewValue: row.data})
+		} else if !sameData(prev.data, row.data) {
+			events = append(events, WatchEvent{Type: WatchPut, Key: row.key, UUID: uuid, Revision: row.revision(), OldValue: prev.data, NewValue: row.data})
+		}
+	}
+	for uuid, row := range old {
+		if _, ok := h.rows[uuid]; !ok {
+			events = append(events, WatchEvent{Type: WatchDelete, Key: row.key, UUID: uuid, OldValue: row.data})
+		}
+	}
+	for w := range h.watchers {
+		var matched []WatchEvent
+		for _, ev := range events {
+			if ev.Type == WatchResync || w.match(ev.Key) {
+				matched = append(matched, ev)
+			}
+		}
+		w.push(matched...)
+	}
+}
+
+// apply updates known rows and, if notify, pushes events to watchers
+func (h *watchHub) apply(updates libovsdb.TableUpdates, notify bool) {
+	var events []WatchEvent
+	for _, table := range updates.Updates {
+		for uuid, update := range table.Rows {
+			prev, known := h.rows[uuid]
+			fields := update.New.Fields
+			if fields == nil {
+				if !known {
+					continue
+				}
+				h.remove(uuid)
+				events = append(events, WatchEvent{Type: WatchDelete, Key: prev.key, UUID: uuid, OldValue: prev.data})
+				continue
+			}
+
+			if h.o.renamed() {
+				fields = h.o.renameRow(fields, h.o.fromServer)
+			}
+			row := prev
+			row.fields = make(map[string]interface{}, len(fields))
+			for k, v := range prev.fields {
+				row.fields[k] = v
+			}
+			for k, v := range fields {
+				row.fields[k] = v
+			}
+			if path, ok := fields["path"]; ok {
+				row.key = pathKey(path)
+			}
+			if data, ok := fields["data"]; ok {
+				row.data = dataMap(data)
+			}
+			h.put(uuid, row)
+			ev := WatchEvent{Type: WatchPut, Key: row.key, UUID: uuid, Revision: row.revision(), NewValue: row.data}
+			if known {
+				ev.OldValue = prev.data
+			}
+			events = append(events, ev)
+		}
+	}
+	if !notify {
+		return
+	}
+	for w := range h.watchers {
+		var matched []WatchEvent
+		for _, ev := range events {
+			if w.match(ev.Key) {
+				matched = append(matched, ev)
+			}
+		}
+		if len(matched) > 0 {
+			w.push(matched...)
+		}
+	}
+}
+
+func (h *watchHub) put(uuid string, row watchRow) {
+	if prev, ok := h.rows[uuid]; ok && prev.key != row.key {
+		delete(h.keys, prev.key)
+	}
+	h.rows[uuid] = row
+	h.keys[row.key] = uuid
+}
+
+func (h *watchHub) remove(uuid string) {
+	if row, ok := h.rows[uuid]; ok {
+		if h.keys[row.key] == uuid {
+			delete(h.keys, row.key)
+		}
+		delete(h.rows, uuid)
+	}
+}
+
+func (r watchRow) revision() uint32 {
+	return rowRevision(r.fields)
+}
+
+func (h *watchHub) close() {
+	h.mu.Lock()
+	watchers := h.watchers
+	h.watchers = make(map[*Watcher]bool)
+	h.mu.Unlock()
+
+	for w := range watchers {
+		w.once.Do(func() { close(w.done) })
+	}
+}
+
+// Update implements libovsdb.NotificationHandler
+func (h *watchHub) Update(context interface{}, updates libovsdb.TableUpdates) {
+	if context != h.context() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.syncing {
+		h.pending = append(h.pending, updates)
+		return
+	}
+	h.apply(updates, true)
+}
+
+// Locked implements libovsdb.NotificationHandler
+func (h *watchHub) Locked([]interface{}) {
+}
+
+// Stolen implements libovsdb.NotificationHandler
+func (h *watchHub) Stolen([]interface{}) {
+}
+
+// Echo implements libovsdb.NotificationHandler
+func (h *watchHub) Echo([]interface{}) {
+}
+
+// Disconnected implements libovsdb.NotificationHandler. Monitor is
+// re-issued by OvsKVImpl once it reconnects.
+func (h *watchHub) Disconnected(*libovsdb.OvsdbClient) {
+	h.mu.Lock()
+	h.live = false
+	h.mu.Unlock()
+}
+
+// dataMap converts OVSDB data column into string map
+func dataMap(data interface{}) OvsKVMap {
+	m := make(OvsKVMap)
+	if ovsMap, ok := data.(libovsdb.OvsMap); ok {
+		for k, v := range ovsMap.GoMap {
+			m[fmt.Sprintf("%v", k)] = fmt.Sprintf("%v", v)
+		}
+	}
+	return m
+}
+
+func sameData(a, b OvsKVMap) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}